@@ -2,11 +2,17 @@
 
 package netti
 
-import "golang.org/x/sys/unix"
+import (
+	"net"
+	"netti/internal/netpoll"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
 
 // acceptNewConnection 接收并创建新的连接
-func (svr *server) acceptNewConnection(fd int) error {
-	nfd, sa, err := unix.Accept(fd)
+func (svr *server) acceptNewConnection(ln *listener) error {
+	nfd, sa, err := unix.Accept(ln.fd)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
@@ -17,15 +23,160 @@ func (svr *server) acceptNewConnection(fd int) error {
 	if err := unix.SetNonblock(nfd, true); err != nil {
 		return err
 	}
-	el := svr.subLoopGroup.next()
-	c := newTCPConn(nfd, el, sa)
-	_ = el.poller.Trigger(func() (err error) {
+	ip, ok := svr.admit(nfd, sa)
+	if !ok {
+		return nil
+	}
+	remoteAddr := netpoll.SockaddrToTCPOrUnixAddr(sa)
+	el, release := svr.subLoopGroup.next(remoteAddr)
+	c := newTCPConn(nfd, el, sa, ln)
+	c.remoteAddr = remoteAddr
+	c.admitIP = ip
+	if err := el.poller.Trigger(func() (err error) {
+		release()
 		if err = el.poller.AddRead(nfd); err != nil {
+			_ = unix.Close(nfd)
+			svr.release(ip)
 			return
 		}
-		el.connections[nfd] = c
+		el.addConn(c)
 		err = el.loopOpen(c)
 		return
-	})
+	}); err != nil {
+		// The task is queued but the loop may never wake up to run it.
+		release()
+	}
+	return nil
+}
+
+// limited reports whether the connections are counted against the limits.
+func (svr *server) limited() bool {
+	return svr.opts.MaxConnections > 0 || svr.opts.MaxConnectionsPerIP > 0
+}
+
+// admit runs the accept filters on a newly accepted socket and counts it against the connection limits,
+// the socket is closed if it is rejected. ip is the key the connection is counted by per IP,
+// it should be released with the connection.
+func (svr *server) admit(nfd int, sa unix.Sockaddr) (ip string, ok bool) {
+	ip, ok, filtered := svr.admitPeer(sa)
+	if !ok {
+		if !filtered && len(svr.opts.RejectMessage) > 0 {
+			_, _ = unix.Write(nfd, svr.opts.RejectMessage)
+		}
+		_ = unix.Close(nfd)
+	}
+	return
+}
+
+// admitPeer runs the accept filters on the address of a new connection or UDP session and counts it against
+// the connection limits, filtered reports whether it has been rejected by a filter rather than by the limits.
+func (svr *server) admitPeer(sa unix.Sockaddr) (ip string, ok, filtered bool) {
+	opts := svr.opts
+	if len(opts.AcceptFilters) > 0 {
+		addr := sockaddrIP(sa)
+		for _, f := range opts.AcceptFilters {
+			if !f.Accept(addr) {
+				atomic.AddUint64(&svr.filtered, 1)
+				return "", false, true
+			}
+		}
+	}
+	if !svr.limited() {
+		atomic.AddUint64(&svr.accepted, 1)
+		return "", true, false
+	}
+	if opts.MaxConnectionsPerIP > 0 {
+		if addr := sockaddrIP(sa); addr != nil {
+			ip = addr.String()
+		}
+	}
+	svr.connMu.Lock()
+	full := opts.MaxConnections > 0 && svr.conns >= opts.MaxConnections
+	ok = !full && (ip == "" || svr.connsPerIP[ip] < opts.MaxConnectionsPerIP)
+	if ok {
+		svr.conns++
+		if ip != "" {
+			svr.connsPerIP[ip]++
+		}
+		full = opts.MaxConnections > 0 && svr.conns >= opts.MaxConnections
+	}
+	pause := full && opts.ConnLimitPolicy == PauseAccepting && !svr.paused
+	if pause {
+		svr.paused = true
+	}
+	svr.connMu.Unlock()
+	if pause {
+		svr.updateAccepting()
+	}
+
+	if ok {
+		atomic.AddUint64(&svr.accepted, 1)
+	} else {
+		atomic.AddUint64(&svr.rejected, 1)
+	}
+	return
+}
+
+// release uncounts a closed connection admitted with ip.
+func (svr *server) release(ip string) {
+	if !svr.limited() {
+		return
+	}
+	svr.connMu.Lock()
+	svr.conns--
+	if ip != "" {
+		if svr.connsPerIP[ip]--; svr.connsPerIP[ip] <= 0 {
+			delete(svr.connsPerIP, ip)
+		}
+	}
+	resume := svr.paused && svr.conns < svr.opts.MaxConnections
+	if resume {
+		svr.paused = false
+	}
+	svr.connMu.Unlock()
+	if resume {
+		svr.updateAccepting()
+	}
+}
+
+// updateAccepting stops or resumes polling the stream listeners as svr.paused says. It takes mu, so it must not
+// be called with connMu held, which is taken after mu. The state is read within the loops, so that the updates
+// racing with each other end up with the latest state.
+func (svr *server) updateAccepting() {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	for _, ln := range svr.listeners {
+		if ln.pconn != nil {
+			continue
+		}
+		ln := ln
+		for _, el := range svr.loops(ln) {
+			el := el
+			_ = el.poller.Trigger(func() error {
+				if el.listeners[ln.fd] != ln {
+					return nil // removed meanwhile
+				}
+				svr.connMu.Lock()
+				pause := svr.paused
+				svr.connMu.Unlock()
+				if pause {
+					_ = el.poller.Delete(ln.fd)
+				} else if !svr.isShutdown() {
+					_ = el.poller.AddRead(ln.fd)
+				}
+				return nil
+			})
+		}
+	}
+}
+
+// sockaddrIP returns the IP of an internet socket address, or nil for the others.
+func sockaddrIP(sa unix.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return append(net.IP(nil), sa.Addr[:]...)
+	case *unix.SockaddrInet6:
+		return append(net.IP(nil), sa.Addr[:]...)
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+// +build linux
+
+package netti
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// The environment variables of systemd socket activation, see sd_listen_fds(3).
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	listenFDsStart   = 3
+)
+
+// activatedFile is a socket passed by systemd socket activation.
+type activatedFile struct {
+	name string
+	f    *os.File
+}
+
+var (
+	activationOnce sync.Once
+	activationMu   sync.Mutex
+	activated      []*activatedFile
+)
+
+// loadActivatedFiles collects the sockets passed by systemd to the current process, the environment
+// variables are unset to prevent the child processes from taking them for their own.
+func loadActivatedFiles() {
+	pid, err := strconv.Atoi(os.Getenv(envListenPID))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	for _, env := range []string{envListenPID, envListenFDs, envListenFDNames} {
+		sniffError(os.Unsetenv(env))
+	}
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		unix.CloseOnExec(fd)
+		af := &activatedFile{f: os.NewFile(uintptr(fd), "systemd-"+strconv.Itoa(fd))}
+		if i < len(names) {
+			af.name = names[i]
+		}
+		activated = append(activated, af)
+	}
+}
+
+// activatedListener returns the file of the socket passed by systemd socket activation with the given
+// name or, if there is no such a name, with the given index. Every socket can be taken only once.
+func activatedListener(name string) (*os.File, error) {
+	activationOnce.Do(loadActivatedFiles)
+	activationMu.Lock()
+	defer activationMu.Unlock()
+	idx := -1
+	for i, af := range activated {
+		if af != nil && af.name == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(activated) && activated[i] != nil {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, ErrListenerNotActivated
+	}
+	f := activated[idx].f
+	activated[idx] = nil
+	return f, nil
+}
+
+// socketNetwork detects the network of the socket, which is one of "tcp", "udp" and "unix".
+func socketNetwork(fd int) (string, error) {
+	typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return "", err
+	}
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case domain == unix.AF_UNIX && typ == unix.SOCK_STREAM:
+		return "unix", nil
+	case (domain == unix.AF_INET || domain == unix.AF_INET6) && typ == unix.SOCK_STREAM:
+		return "tcp", nil
+	case (domain == unix.AF_INET || domain == unix.AF_INET6) && typ == unix.SOCK_DGRAM:
+		return "udp", nil
+	}
+	return "", ErrProtocolNotSupported
+}
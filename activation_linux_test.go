@@ -0,0 +1,64 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// envTestActivationChild indicates the process is the child of TestSocketActivation.
+const envTestActivationChild = "NETTI_TEST_ACTIVATION_CHILD"
+
+func TestSocketActivation(t *testing.T) {
+	if os.Getenv(envTestActivationChild) != "" {
+		// The pid of the child is unknown until it is started, systemd sets it up instead in practice.
+		_ = os.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := ServeContext(ctx, &testHotRestartChild{new(EventServer)}, "systemd://web",
+			WithListener("systemd://1")); err != nil {
+			t.Fatalf("child failed to serve: %v", err)
+		}
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnFile, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconnFile, err := pconn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivation$")
+	cmd.Env = append(os.Environ(), envTestActivationChild+"=1", envListenFDs+"=2", envListenFDNames+"=web:stats")
+	cmd.ExtraFiles = []*os.File{lnFile, pconnFile}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("failed to start child: %v", err)
+	}
+	for _, c := range []interface{ Close() error }{ln, lnFile, pconn, pconnFile} {
+		_ = c.Close()
+	}
+
+	testRequest(t, "udp", pconn.LocalAddr().String(), "udp", "child:udp")
+	testRequest(t, "tcp", ln.Addr().String(), "tcp", "child:tcp")
+	if err = cmd.Wait(); err != nil {
+		t.Fatalf("child exits with error: %v", err)
+	}
+}
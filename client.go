@@ -0,0 +1,51 @@
+package netti
+
+import (
+	"context"
+)
+
+// Client dials outbound connections and serves them with its own event-loops, in the same way as a server
+// does with the accepted ones: the connections are distributed by the load-balancer, the events are fired
+// on the EventHandler and the stream is framed by the codec. It is safe to use from any goroutine.
+type Client struct {
+	h *ServerHandle
+}
+
+// NewClient starts the event-loops of a client, the options apply as they do to a server except the ones of
+// listening and accepting, ConnectTimeout applies to the client only.
+func NewClient(eventHandler EventHandler, opts ...Option) (*Client, error) {
+	svr, err := serve(eventHandler, nil, loadOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+	if svr == nil {
+		return nil, ErrServerShutdown
+	}
+	return &Client{h: newServerHandle(svr)}, nil
+}
+
+// Dial connects to addr, formatted like `tcp://192.168.0.10:9851` or `unix://socket`, OnOpened is fired
+// within the event-loop of the connection once it has been established. It fails with a *net.OpError
+// wrapping the cause, e.g. ECONNREFUSED or ErrConnectTimeout.
+func (cli *Client) Dial(addr string) (Conn, error) {
+	return cli.DialContext(context.Background(), addr)
+}
+
+// DialContext is like Dial but gives up once ctx is done, the deadline of ctx bounds the connect timeout.
+func (cli *Client) DialContext(ctx context.Context, addr string) (Conn, error) {
+	c, err := cli.h.svr.dial(ctx, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stop closes all the connections and stops the event-loops, see ServerHandle.Stop.
+func (cli *Client) Stop(ctx context.Context) error {
+	return cli.h.Stop(ctx)
+}
+
+// Done returns a channel that is closed once the client has been stopped.
+func (cli *Client) Done() <-chan struct{} {
+	return cli.h.Done()
+}
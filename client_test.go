@@ -0,0 +1,116 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type testClientHandler struct {
+	*EventServer
+	opened chan Conn
+	frames chan string
+}
+
+func (ch *testClientHandler) OnOpened(c Conn) (out []byte, action Action) {
+	ch.opened <- c
+	return
+}
+
+func (ch *testClientHandler) React(frame []byte, c Conn) (out []byte, action Action) {
+	ch.frames <- string(frame)
+	return
+}
+
+func TestClient(t *testing.T) {
+	h, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	ch := &testClientHandler{EventServer: new(EventServer), opened: make(chan Conn, 1), frames: make(chan string, 1)}
+	cli, err := NewClient(ch, WithNumEventLoop(2), WithConnectTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer cli.Stop(context.Background())
+
+	c, err := cli.Dial("tcp://" + h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if opened := <-ch.opened; opened != c {
+		t.Fatal("OnOpened should be fired with the dialed connection")
+	}
+	if c.RemoteAddr().String() != h.Server().Addr.String() || c.LocalAddr() == nil {
+		t.Fatalf("unexpected addresses of the dialed connection: %v -> %v", c.LocalAddr(), c.RemoteAddr())
+	}
+	_ = c.AsyncWrite([]byte("hello"))
+	select {
+	case frame := <-ch.frames:
+		if frame != "hello" {
+			t.Fatalf("unexpected echo: %q", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no echo is received")
+	}
+
+	// A refused connect.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	if _, err = cli.Dial("tcp://" + addr); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("dialing a closed port should be refused, got: %v", err)
+	}
+}
+
+func TestClientConnectTimeout(t *testing.T) {
+	// A listener which never accepts drops the SYNs once its backlog is full.
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err = unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, _ := unix.Getsockname(fd)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: sa.(*unix.SockaddrInet4).Port}
+
+	cli, err := NewClient(new(EventServer), WithConnectTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer cli.Stop(context.Background())
+	for i := 0; i < 8; i++ {
+		_, err = cli.Dial("tcp://" + addr.String())
+		if err != nil {
+			break
+		}
+	}
+	var ne net.Error
+	if !errors.Is(err, ErrConnectTimeout) || !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("dialing a full backlog should time out, got: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = cli.DialContext(ctx, "tcp://"+addr.String()); !errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("dialing should give up at the deadline of the context, got: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"netti/internal/netpoll"
+	"netti/internal/timingwheel"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// dialer is the state of a connection being dialed.
+type dialer struct {
+	claimed int32 // set by whoever takes over the socket first, the loop or the abandoned dial, accessed atomically
+	done    chan error
+	attach  func(c *conn) // invoked within the loop once connected, before OnOpened
+	host    string        // host of the dialed address, the server name of TLS by default
+	timer   *timingwheel.Timer
+	op      *net.OpError
+}
+
+// dial connects to addr with a non-blocking connect, the completion is polled by a sub-loop.
+// attach, if not nil, is invoked within the loop once connected, before OnOpened.
+func (svr *server) dial(ctx context.Context, addr string, attach func(c *conn)) (*conn, error) {
+	network, address := parseAddr(addr)
+	op := &net.OpError{Op: "dial", Net: network}
+	fail := func(err error) (*conn, error) {
+		op.Err = err
+		return nil, op
+	}
+	if svr.isShutdown() {
+		return fail(ErrServerShutdown)
+	}
+	timeout := svr.opts.ConnectTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return fail(context.DeadlineExceeded)
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	sa, raddr, err := resolveSockaddr(network, address)
+	if err != nil {
+		return fail(err)
+	}
+	op.Addr = raddr
+
+	fd, err := unix.Socket(sockaddrDomain(sa), unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fail(os.NewSyscallError("socket", err))
+	}
+	if err = unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		_ = unix.Close(fd)
+		return fail(os.NewSyscallError("connect", err))
+	}
+
+	el, release := svr.subLoopGroup.next(raddr)
+	c := newDialedConn(fd, el, svr, sa, raddr)
+	d := &dialer{done: make(chan error, 1), attach: attach, op: op}
+	d.host, _, _ = net.SplitHostPort(address)
+	if err = el.poller.Trigger(func() error {
+		release()
+		if atomic.CompareAndSwapInt32(&d.claimed, 0, 1) {
+			el.loopDial(c, d, timeout)
+		}
+		return nil
+	}); err != nil && atomic.CompareAndSwapInt32(&d.claimed, 0, 2) {
+		// The task is queued but the loop may never wake up to run it, it gives up the dial if it does.
+		release()
+		_ = unix.Close(fd)
+		return fail(err)
+	}
+
+	select {
+	case err = <-d.done:
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&d.claimed, 0, 2) {
+			_ = unix.Close(fd)
+			return fail(ctx.Err())
+		}
+		_ = el.poller.Trigger(func() error {
+			if c.dialer == d {
+				el.failDial(c, ctx.Err())
+			} else if c.tls != nil && c.tls.dialer == d {
+				return el.loopCloseConn(c, ctx.Err())
+			}
+			return nil
+		})
+		err = <-d.done
+	case <-svr.closing:
+		if atomic.CompareAndSwapInt32(&d.claimed, 0, 2) {
+			_ = unix.Close(fd)
+			return fail(ErrServerShutdown)
+		}
+		// The socket has been taken over by the loop, it is failed on shutdown.
+		err = <-d.done
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loopDial polls the connecting socket for writing, which indicates that the connect has completed.
+func (el *eventloop) loopDial(c *conn, d *dialer, timeout time.Duration) {
+	if err := el.poller.AddWrite(c.fd); err != nil {
+		_ = unix.Close(c.fd)
+		d.op.Err = err
+		d.done <- d.op
+		return
+	}
+	c.dialer = d
+	el.dialing[c.fd] = c
+	if timeout > 0 {
+		d.timer = el.wheel.AfterFunc(timeout, func() error {
+			if c.dialer == d {
+				el.failDial(c, ErrConnectTimeout)
+			}
+			return nil
+		})
+	}
+}
+
+// loopConnect completes the connect of a dialed connection and opens it.
+func (el *eventloop) loopConnect(c *conn) error {
+	errno, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = unix.Errno(errno)
+	}
+	if err == nil {
+		err = el.poller.ModRead(c.fd)
+	}
+	if err != nil {
+		el.failDial(c, os.NewSyscallError("connect", err))
+		return nil
+	}
+	d := c.dialer
+	el.stopDialing(c)
+	if sa, err := unix.Getsockname(c.fd); err == nil {
+		c.localAddr = netpoll.SockaddrToTCPOrUnixAddr(sa)
+	}
+	el.addConn(c)
+	if config := c.svr.opts.TLSConfig; config != nil {
+		return el.loopHandshake(c, config, d)
+	}
+	if d.attach != nil {
+		d.attach(c)
+	}
+	d.done <- nil
+	return el.loopOpen(c)
+}
+
+// failDial closes the socket of a connection being dialed and reports err to the dial.
+func (el *eventloop) failDial(c *conn, err error) {
+	d := c.dialer
+	el.stopDialing(c)
+	_ = el.poller.Delete(c.fd)
+	_ = unix.Close(c.fd)
+	c.releaseTCP()
+	d.op.Err = err
+	d.done <- d.op
+}
+
+// stopDialing removes the connection from the dialing ones.
+func (el *eventloop) stopDialing(c *conn) {
+	delete(el.dialing, c.fd)
+	if c.dialer.timer != nil {
+		c.dialer.timer.Stop()
+	}
+	c.dialer = nil
+}
+
+// resolveSockaddr resolves the address of a stream network to a socket address.
+func resolveSockaddr(network, address string) (unix.Sockaddr, net.Addr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if addr.IP == nil {
+			// Dial the local system for an empty host as net.Dial does.
+			addr.IP = net.IPv4(127, 0, 0, 1)
+			if network == "tcp6" {
+				addr.IP = net.IPv6loopback
+			}
+		}
+		if ip4 := addr.IP.To4(); ip4 != nil && network != "tcp6" {
+			sa := &unix.SockaddrInet4{Port: addr.Port}
+			copy(sa.Addr[:], ip4)
+			return sa, addr, nil
+		}
+		sa := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa.Addr[:], addr.IP.To16())
+		if addr.Zone != "" {
+			if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, addr, nil
+	case "unix":
+		return &unix.SockaddrUnix{Name: address}, &net.UnixAddr{Name: address, Net: network}, nil
+	}
+	return nil, nil, net.UnknownNetworkError(network)
+}
+
+// sockaddrDomain returns the address family of a socket address.
+func sockaddrDomain(sa unix.Sockaddr) int {
+	switch sa.(type) {
+	case *unix.SockaddrInet4:
+		return unix.AF_INET
+	case *unix.SockaddrInet6:
+		return unix.AF_INET6
+	}
+	return unix.AF_UNIX
+}
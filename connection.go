@@ -1,6 +1,10 @@
 package netti
 
-import "net"
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
 
 // Conn 客户端连接的接口
 type Conn interface {
@@ -39,6 +43,17 @@ type Conn interface {
 	// SendTo 为UDP套接字写数据, 它允许你发送数据回UDP套接字在单独的goroutines
 	SendTo(buf []byte) error
 
+	// SendToSegmented 把 buf 按 segmentSize 切分成多个数据报发回 UDP 对端, 最后一个可以更短, 内核支持时通过
+	// UDP_SEGMENT 在一次系统调用中发送, 不支持时逐个发送, 和 SendTo 一样可以在单独的 goroutine 中调用
+	SendToSegmented(buf []byte, segmentSize int) error
+
+	// JoinGroup 让接收该数据报的 UDP 监听器在运行时加入多播组, source 不为 nil 时只接收该源发送的数据报,
+	// 即指定源多播, 多播组在 MulticastConfig.Interface 指定的网卡上加入, 不是 UDP 监听器的连接返回 ErrNotUDPListener
+	JoinGroup(group, source net.IP) error
+
+	// LeaveGroup 让 UDP 监听器离开以相同参数通过 JoinGroup 或 MulticastConfig 加入的多播组
+	LeaveGroup(group, source net.IP) error
+
 	// AsyncWrite 异步地将数据写入客户端连接，通常你需要在单个goroutine中调用它而不是事件循环中
 	AsyncWrite(buf []byte) error
 
@@ -47,4 +62,42 @@ type Conn interface {
 
 	// Close 关闭当前连接.
 	Close() error
+
+	// SetDeadline 同时设置连接的读期限和写期限, 见 SetReadDeadline 和 SetWriteDeadline
+	SetDeadline(t time.Time) error
+
+	// SetReadDeadline 设置连接的读期限, 如果到期时自设置以来还没有解码出完整的帧, 事件循环会以 ErrReadTimeout
+	// 关闭连接, 新的期限会替换之前的期限, 零值表示取消期限, 可以在任意 goroutine 中调用
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline 设置连接的写期限, 如果到期时出站缓冲区中还有数据没有写出, 事件循环会以 ErrWriteTimeout
+	// 关闭连接, 新的期限会替换之前的期限, 零值表示取消期限, 可以在任意 goroutine 中调用
+	SetWriteDeadline(t time.Time) error
+
+	// SetRateLimit 设置连接每秒最多读取和写出的字节数, 零表示不限制, 读取超出限制时事件循环暂停读取该连接直到令牌补充,
+	// 写出超出限制的数据留在出站缓冲区中稍后写出, 服务器的总限制见 Options.ReadRateLimit,
+	// 它只能在事件循环中调用, 例如在 OnOpened 或连接的定时器中
+	SetRateLimit(readBps, writeBps int64)
+
+	// AfterFunc 在 d 时间后于连接所在的事件循环中调用 f, 可以在任意 goroutine 中调用,
+	// 定时器只在连接打开期间触发, 连接关闭时尚未触发的定时器会被取消
+	AfterFunc(d time.Duration, f func(c Conn)) Timer
+
+	// Every 每隔 d 时间于连接所在的事件循环中调用一次 f, 直到定时器被停止或连接关闭, d 必须为正数, 否则会 panic
+	Every(d time.Duration, f func(c Conn)) Timer
+
+	// TLSConnectionState 返回 TLS 连接握手完成后的状态, 包括 SNI 的服务器名 ServerName, ALPN 协商的协议
+	// NegotiatedProtocol 和对端的证书 PeerCertificates, 明文连接返回 nil
+	TLSConnectionState() *tls.ConnectionState
+
+	// ProxyHeader 返回连接或数据报开头的 PROXY 协议头, 包括代理传递的客户端地址和 v2 的 TLV,
+	// 例如 Authority 和 UniqueID, 没有协议头时返回 nil
+	ProxyHeader() *ProxyHeader
+}
+
+// Timer 连接定时器的句柄, 定时器由事件循环的时间轮驱动, 精度为 10 毫秒
+type Timer interface {
+	// Stop 取消定时器, 可以在任意 goroutine 中调用, 如果定时器已经触发或已被停止则返回 false,
+	// 周期定时器总是可以被停止
+	Stop() bool
 }
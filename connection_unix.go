@@ -6,6 +6,9 @@ import (
 	"github.com/panjf2000/gnet/ringbuffer"
 	"net"
 	"netti/internal/netpoll"
+	"netti/internal/timingwheel"
+	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
@@ -13,32 +16,73 @@ import (
 )
 
 type conn struct {
-	fd         int                    // 文件描述符
-	sa         unix.Sockaddr          // 远程套接字地址
-	ctx        interface{}            // 用户定义的上下文
-	loop       *eventloop             // 连接所处的事件循环
-	buffer     []byte                 // 接收数据的临时缓冲区内存重用
-	codec      ICodec                 // TCP编解码器
-	opened     bool                   // 连接被打开事件会触发
-	localAddr  net.Addr               // 本地地址
-	remoteAddr net.Addr               // 远程地址
-	byteBuffer *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
-	inBuffer   *ringbuffer.RingBuffer // 来自 client 数据的缓冲区
-	outBuffer  *ringbuffer.RingBuffer // 准备写入client的数据的缓冲区
+	fd          int                             // 文件描述符
+	sa          unix.Sockaddr                   // 远程套接字地址
+	ctx         interface{}                     // 用户定义的上下文
+	loop        *eventloop                      // 连接所处的事件循环
+	svr         *server                         // 连接所属的服务器或客户端
+	ln          *listener                       // 接受连接的监听器
+	buffer      []byte                          // 接收数据的临时缓冲区内存重用
+	codec       ICodec                          // TCP编解码器
+	opened      bool                            // 连接被打开事件会触发
+	localAddr   net.Addr                        // 本地地址
+	remoteAddr  net.Addr                        // 远程地址
+	byteBuffer  *bytebuffer.ByteBuffer          // bytes buffer for buffering current packet and data in ring-buffer
+	inBuffer    *ringbuffer.RingBuffer          // 来自 client 数据的缓冲区
+	outBuffer   *ringbuffer.RingBuffer          // 准备写入client的数据的缓冲区
+	pending     int32                           // 出站缓冲区中数据的长度, 供其他 goroutine 原子地读取
+	events      uint32                          // 连接在 poller 中关注的事件, 为零时不在 poller 中
+	readLimit   *rateLimiter                    // 读取速率的限制
+	writeLimit  *rateLimiter                    // 写出速率的限制
+	readPaused  bool                            // 是否因为速率限制暂停读取
+	writePaused bool                            // 是否因为速率限制暂停写出
+	dialer      *dialer                         // 正在建立连接的拨号状态
+	admitIP     string                          // 连接按 IP 计数所用的键
+	timers      map[*timingwheel.Timer]struct{} // 连接上等待触发的定时器
+	lastRead    time.Time                       // 最近一次读到数据的时间
+	lastWrite   time.Time                       // 最近一次写出数据的时间
+	frames      uint64                          // 已经解码出的帧数
+	readTimer   *timingwheel.Timer              // 读期限的定时器
+	writeTimer  *timingwheel.Timer              // 写期限的定时器
+	tls         *tlsSession                     // TLS 连接的会话, 明文连接为 nil
+	proxy       *ProxyHeader                    // PROXY 协议头, 没有时为 nil
+	proxyWait   *proxyWait                      // 等待 PROXY 协议头的状态, 读完协议头后为 nil
+	session     *sessionKey                     // UDP 会话的键, 不是 UDP 会话时为 nil
 }
 
 // newTCPConn .
-func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
+func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, ln *listener) *conn {
+	var wait *proxyWait
+	if ln.proxyMode != ProxyProtocolOff {
+		wait = new(proxyWait)
+	}
 	return &conn{
 		fd:        fd,
 		sa:        sa,
 		loop:      el,
-		codec:     el.codec,
+		svr:       ln.svr,
+		ln:        ln,
+		codec:     ln.codec,
+		proxyWait: wait,
 		inBuffer:  prb.Get(),
 		outBuffer: prb.Get(),
 	}
 }
 
+// newDialedConn .
+func newDialedConn(fd int, el *eventloop, svr *server, sa unix.Sockaddr, remoteAddr net.Addr) *conn {
+	return &conn{
+		fd:         fd,
+		sa:         sa,
+		loop:       el,
+		svr:        svr,
+		codec:      svr.codec,
+		remoteAddr: remoteAddr,
+		inBuffer:   prb.Get(),
+		outBuffer:  prb.Get(),
+	}
+}
+
 // releaseTCP .
 func (c *conn) releaseTCP() {
 	c.opened = false
@@ -47,6 +91,9 @@ func (c *conn) releaseTCP() {
 	c.buffer = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.tls = nil
+	c.proxy = nil
+	c.proxyWait = nil
 	prb.Put(c.inBuffer)
 	prb.Put(c.outBuffer)
 	c.inBuffer = nil
@@ -56,11 +103,14 @@ func (c *conn) releaseTCP() {
 }
 
 // newUDPConn .
-func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
+func newUDPConn(el *eventloop, sa unix.Sockaddr, ln *listener) *conn {
 	return &conn{
-		fd:         fd,
+		fd:         ln.fd,
 		sa:         sa,
-		localAddr:  el.svr.ln.lnaddr,
+		loop:       el,
+		svr:        ln.svr,
+		ln:         ln,
+		localAddr:  ln.lnaddr,
 		remoteAddr: netpoll.SockaddrToUDPAddr(sa),
 	}
 }
@@ -68,17 +118,55 @@ func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
 // releaseUDP .
 func (c *conn) releaseUDP() {
 	c.ctx = nil
+	c.ln = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.proxy = nil
+}
+
+// newUDPSession .
+func newUDPSession(el *eventloop, sa unix.Sockaddr, ln *listener, key sessionKey) *conn {
+	return &conn{
+		fd:         ln.fd,
+		sa:         sa,
+		loop:       el,
+		svr:        ln.svr,
+		ln:         ln,
+		codec:      datagramCodec,
+		localAddr:  ln.lnaddr,
+		remoteAddr: netpoll.SockaddrToUDPAddr(sa),
+		session:    &key,
+	}
+}
+
+// releaseSession .
+func (c *conn) releaseSession() {
+	c.opened = false
+	c.ctx = nil
+	c.localAddr = nil
+	c.remoteAddr = nil
+	c.proxy = nil
 }
 
 // open .
 func (c *conn) open(buf []byte) {
+	if c.tls != nil {
+		var err error
+		if buf, err = c.tls.seal(buf); err != nil {
+			_ = c.loop.loopCloseConn(c, err)
+			return
+		}
+	}
+	if c.shaped() {
+		_, _ = c.outBuffer.Write(buf)
+		return
+	}
 	n, err := unix.Write(c.fd, buf)
 	if err != nil {
 		_, _ = c.outBuffer.Write(buf)
 		return
 	}
+	c.lastWrite = time.Now()
 
 	if n < len(buf) {
 		_, _ = c.outBuffer.Write(buf[n:])
@@ -92,26 +180,62 @@ func (c *conn) read() ([]byte, error) {
 
 // write .
 func (c *conn) write(buf []byte) {
+	if c.session != nil {
+		c.sendSession(buf)
+		return
+	}
+	if c.tls != nil {
+		var err error
+		if buf, err = c.tls.seal(buf); err != nil {
+			_ = c.loop.loopCloseConn(c, err)
+			return
+		}
+		if len(buf) == 0 {
+			return
+		}
+	}
+	c.writeRaw(buf)
+}
+
+// writeRaw writes the data to the socket as it is, the data which can not be written right away is buffered.
+func (c *conn) writeRaw(buf []byte) {
 	if !c.outBuffer.IsEmpty() {
 		_, _ = c.outBuffer.Write(buf)
+		atomic.StoreInt32(&c.pending, int32(c.outBuffer.Length()))
+		return
+	}
+	if c.shaped() {
+		_, _ = c.outBuffer.Write(buf)
+		_ = c.loop.loopWrite(c)
 		return
 	}
 	n, err := unix.Write(c.fd, buf)
 	if err != nil {
 		if err == unix.EAGAIN {
 			_, _ = c.outBuffer.Write(buf)
-			_ = c.loop.poller.ModReadWrite(c.fd)
+			_ = c.loop.updateEvents(c)
 			return
 		}
 		_ = c.loop.loopCloseConn(c, err)
 		return
 	}
+	c.lastWrite = time.Now()
 	if n < len(buf) {
 		_, _ = c.outBuffer.Write(buf[n:])
-		_ = c.loop.poller.ModReadWrite(c.fd)
+		_ = c.loop.updateEvents(c)
 	}
 }
 
+// pendingWrites returns the length of the data in the outbound buffer, it is safe to call from any goroutine.
+func (c *conn) pendingWrites() int {
+	return int(atomic.LoadInt32(&c.pending))
+}
+
+// shaped reports whether the writes to the connection are limited.
+func (c *conn) shaped() bool {
+	return c.writeLimit != nil || c.svr.writeLimit != nil
+}
+
 // sendTo .
 func (c *conn) sendTo(buf []byte) error {
 	return unix.Sendto(c.fd, buf, 0, c.sa)
@@ -224,6 +348,143 @@ func (c *conn) Close() error {
 	})
 }
 
+func (c *conn) AfterFunc(d time.Duration, f func(c Conn)) Timer {
+	return c.schedule(d, false, f)
+}
+
+func (c *conn) Every(d time.Duration, f func(c Conn)) Timer {
+	return c.schedule(d, true, f)
+}
+
+// connTimer is the handle of a timer scheduled by AfterFunc or Every, stopping it takes the timer off the wheel
+// and the connection within the loop, so that the stopped timers do not pile up on a long-lived connection.
+type connTimer struct {
+	*timingwheel.Timer
+	c *conn
+}
+
+// Stop .
+func (t *connTimer) Stop() bool {
+	if !t.Timer.Stop() {
+		return false
+	}
+	_ = t.c.loop.poller.Trigger(func() error {
+		t.c.removeTimer(t.Timer)
+		return nil
+	})
+	return true
+}
+
+// schedule puts a timer running f onto the wheel of the connection's loop.
+func (c *conn) schedule(d time.Duration, periodic bool, f func(c Conn)) *connTimer {
+	var t *timingwheel.Timer
+	t = timingwheel.NewTimer(d, periodic, func() error {
+		if !periodic {
+			delete(c.timers, t)
+		}
+		if c.opened {
+			f(c)
+		}
+		return nil
+	})
+	_ = c.loop.poller.Trigger(func() error {
+		if c.opened && !t.Stopped() {
+			c.addTimer(t)
+		}
+		return nil
+	})
+	return &connTimer{Timer: t, c: c}
+}
+
+// addTimer puts the timer onto the wheel and tracks it until the connection is closed,
+// it must be called within the loop.
+func (c *conn) addTimer(t *timingwheel.Timer) {
+	if c.timers == nil {
+		c.timers = make(map[*timingwheel.Timer]struct{})
+	}
+	c.timers[t] = struct{}{}
+	c.loop.wheel.Schedule(t)
+}
+
+// removeTimer stops the timer and takes it off the wheel and the connection, it must be called within the loop.
+func (c *conn) removeTimer(t *timingwheel.Timer) {
+	if _, ok := c.timers[t]; ok {
+		delete(c.timers, t)
+		c.loop.wheel.Remove(t)
+	} else {
+		t.Stop()
+	}
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.setDeadline(t, true, true)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(t, true, false)
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(t, false, true)
+}
+
+// setDeadline replaces the read and/or write deadline timers of the connection within the loop.
+func (c *conn) setDeadline(deadline time.Time, read, write bool) error {
+	return c.loop.poller.Trigger(func() error {
+		if !c.opened {
+			return nil
+		}
+		if read {
+			frames := c.frames
+			c.readTimer = c.resetDeadline(c.readTimer, deadline, func() bool {
+				return c.frames == frames
+			}, ErrReadTimeout)
+		}
+		if write {
+			c.writeTimer = c.resetDeadline(c.writeTimer, deadline, func() bool {
+				return c.outBuffer != nil && !c.outBuffer.IsEmpty() // the datagrams are not buffered
+			}, ErrWriteTimeout)
+		}
+		return nil
+	})
+}
+
+// resetDeadline stops the timer of the previous deadline and returns the timer of the new one, which
+// closes the connection with err if expired is true by then. A zero deadline means no deadline.
+func (c *conn) resetDeadline(prev *timingwheel.Timer, deadline time.Time, expired func() bool, err error) *timingwheel.Timer {
+	if prev != nil {
+		c.removeTimer(prev)
+	}
+	if deadline.IsZero() {
+		return nil
+	}
+	var t *timingwheel.Timer
+	t = timingwheel.NewTimer(time.Until(deadline), false, func() error {
+		delete(c.timers, t)
+		if c.opened && expired() {
+			return c.loop.loopCloseConn(c, err)
+		}
+		return nil
+	})
+	c.addTimer(t)
+	return t
+}
+
+func (c *conn) SetRateLimit(readBps, writeBps int64) {
+	c.readLimit = newRateLimiter(readBps)
+	c.writeLimit = newRateLimiter(writeBps)
+}
+
+// stopTimers cancels the pending timers of the connection.
+func (c *conn) stopTimers() {
+	for t := range c.timers {
+		c.loop.wheel.Remove(t)
+	}
+	c.timers = nil
+	c.readTimer = nil
+	c.writeTimer = nil
+}
+
 func (c *conn) Context() interface{}       { return c.ctx }
 func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
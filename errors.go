@@ -1,12 +1,47 @@
 package netti
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrProtocolNotSupported 当尝试使用不受支持的协议时发生
 	ErrProtocolNotSupported = errors.New("not supported protocol on this platform")
 	// ErrServerShutdown 在服务器关闭时发生
 	ErrServerShutdown = errors.New("server is going to be shutdown")
+	// ErrListenerNotFound 当要移除的监听器不存在时发生
+	ErrListenerNotFound = errors.New("there is no such a listener")
+	// ErrListenerNotActivated 当 systemd socket activation 没有传入指定的套接字时发生
+	ErrListenerNotActivated = errors.New("there is no such a socket passed by systemd")
+	// ErrFirstFrameTimeout 当连接在规定的时间内没有发送完整的第一帧数据时发生
+	ErrFirstFrameTimeout error = &TimeoutError{Op: "first frame"}
+	// ErrReadTimeout 当连接的读期限到期时还没有解码出完整的帧时发生
+	ErrReadTimeout error = &TimeoutError{Op: "read"}
+	// ErrWriteTimeout 当连接的写期限到期时出站缓冲区中还有数据时发生
+	ErrWriteTimeout error = &TimeoutError{Op: "write"}
+	// ErrConnectTimeout 当客户端在连接超时时间内没有建立连接时发生
+	ErrConnectTimeout error = &TimeoutError{Op: "connect"}
+	// ErrHandshakeTimeout 当 TLS 握手没有在规定的时间内完成时发生
+	ErrHandshakeTimeout error = &TimeoutError{Op: "tls handshake"}
+	// ErrProxyHeader 当连接或数据报开头的 PROXY 协议头缺失或格式错误时发生
+	ErrProxyHeader = errors.New("missing or malformed PROXY protocol header")
+	// ErrProxyHeaderTimeout 当 PROXY 协议头是必需的而连接没有在规定的时间内发送完整的协议头时发生
+	ErrProxyHeaderTimeout error = &TimeoutError{Op: "proxy header"}
+	// ErrSessionExpired 当 UDP 会话在空闲超时时间内没有收到对端的数据报时发生
+	ErrSessionExpired error = &TimeoutError{Op: "udp session"}
+	// ErrNotUDPListener 当在不属于 UDP 监听器的连接上加入或离开多播组时发生
+	ErrNotUDPListener = errors.New("connection is not one of a udp listener")
+	// ErrMulticastUnicastAddr 当绑定到单播地址的 UDP 监听器配置了多播组时发生
+	ErrMulticastUnicastAddr = errors.New("multicast groups can not be joined by a listener bound to a unicast address")
+	// ErrTargetNotFound 当连接池中没有指定的目标时发生
+	ErrTargetNotFound = errors.New("there is no such a target in the pool")
+	// ErrPoolExhausted 当目标的连接数已达上限且都还没有建立时发生
+	ErrPoolExhausted = errors.New("all the connections to the target are being dialed")
+	// ErrCircuitOpen 当目标因为连续拨号失败而熔断时发生
+	ErrCircuitOpen = errors.New("circuit breaker of the target is open")
+	// ErrEventLoopGroupStopped 当服务器或客户端使用已经停止的事件循环组时发生
+	ErrEventLoopGroupStopped = errors.New("event-loop group has been stopped")
 	// ErrInvalidFixedLength 当输出数据具有无效的固定长度时发生
 	ErrInvalidFixedLength = errors.New("invalid fixed length of bytes")
 	// ErrUnexpectedEOF 当没有足够的数据可供编解码器读取时发生
@@ -20,3 +55,55 @@ var (
 	// ErrTooLessLength 当调整帧长度小于零时发生
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
 )
+
+// ServerError 描述了服务器因为事件循环退出而关闭的原因, 通过 ServerHandle.Stop 关闭服务器时不会产生.
+// 事件回调返回 Shutdown 时它由 ServerHandle.Cause 返回, 而 Serve 和 ServerHandle.Wait 返回 nil,
+// 此时 errors.Is(err, ErrServerShutdown) 成立.
+type ServerError struct {
+	// Loop 是退出的事件循环的序号, 主 reactor 为 -1.
+	Loop int
+
+	// Err 是事件循环退出时的错误, 事件回调返回 Shutdown 时为 ErrServerShutdown.
+	Err error
+
+	// Shutdown 表示服务器是被事件回调返回 Shutdown 主动关闭的, 而不是因为内部故障.
+	Shutdown bool
+}
+
+// Error .
+func (e *ServerError) Error() string {
+	loop := "main reactor"
+	if e.Loop >= 0 {
+		loop = fmt.Sprintf("event-loop:%d", e.Loop)
+	}
+	if e.Shutdown {
+		return fmt.Sprintf("%s shuts the server down: %v", loop, e.Err)
+	}
+	return fmt.Sprintf("%s fails with error: %v", loop, e.Err)
+}
+
+// Unwrap returns the error the event-loop exits with.
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError 是连接因为期限到期而被关闭的原因, 会传给 OnClosed, 它实现了 net.Error.
+type TimeoutError struct {
+	// Op 是超时的操作, 为 "read", "write", "first frame" 或 "connect".
+	Op string
+}
+
+// Error .
+func (e *TimeoutError) Error() string {
+	return e.Op + " deadline exceeded"
+}
+
+// Timeout always returns true.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary always returns true.
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
@@ -19,6 +19,34 @@ const (
 	Shutdown
 )
 
+// IdleKind 连接空闲的类型
+type IdleKind int
+
+const (
+	// ReaderIdle 表示连接在一段时间内没有读到数据
+	ReaderIdle IdleKind = iota
+
+	// WriterIdle 表示连接在一段时间内没有写出数据
+	WriterIdle
+
+	// AllIdle 表示连接在一段时间内既没有读也没有写
+	AllIdle
+)
+
+// String .
+func (k IdleKind) String() string {
+	switch k {
+	case ReaderIdle:
+		return "reader-idle"
+	case WriterIdle:
+		return "writer-idle"
+	case AllIdle:
+		return "all-idle"
+	default:
+		return "unknown"
+	}
+}
+
 var defaultLogger = log.NewLogger()
 
 // EventHandler 表示服务调用的服务器事件的回调,每个事件都有一个用于管理连接和服务器的状态的动作返回值。
@@ -27,17 +55,23 @@ type EventHandler interface {
 	// OnInitComplete 当服务器准备接受连接时触发, 服务器参数包含服务器参数信息
 	OnInitComplete(server Server) (action Action)
 
+	// OnShutdown 在服务器关闭, 所有事件循环和连接都被关闭之后触发
+	OnShutdown(server Server)
+
 	// OnOpened 在连接被打开时触发
 	OnOpened(c Conn) (out []byte, action Action)
 
 	// OnClosed 在连接被关闭时触发
 	OnClosed(c Conn, err error) (action Action)
 
+	// OnIdle 在连接空闲超过 Options 中设置的对应时长时触发, 之后连接每空闲一个时长触发一次
+	OnIdle(c Conn, kind IdleKind) (action Action)
+
 	// React fires when a connection sends the server data.
 	// Invoke c.Read() or c.ReadN(n) within the parameter c to read incoming data from client/connection.
 	// Use the out return value to write data to the client/connection.y
 	React(frame []byte, c Conn) (out []byte, action Action)
 
-	// Tick 服务器启动后每隔delay时间后触发
+	// Tick 服务器启动后每隔delay时间后在第一个事件循环中触发, 由事件循环的时间轮驱动
 	Tick() (delay time.Duration, action Action)
 }
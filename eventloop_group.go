@@ -1,5 +1,14 @@
 package netti
 
+import (
+	"context"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
 // LoadBalance 设置负载均衡策略.
 type LoadBalance int
 
@@ -12,34 +21,130 @@ const (
 	LeastConnections
 )
 
+// EventLoop 是提供给 LoadBalancer 的事件循环的只读视图.
+type EventLoop interface {
+	// Index 返回事件循环在事件循环组中的序号.
+	Index() int
+
+	// Connections 返回事件循环中活动连接的数量, 包括已经分配给它但尚未加入的连接.
+	Connections() int
+}
+
+// LoadBalancer 负责为新的连接选择事件循环, 可以通过 WithLoadBalancer 提供自定义的实现,
+// 例如加权或者基于源 IP 哈希的策略.
+type LoadBalancer interface {
+	// Next 从 loops 中选出处理来自 remoteAddr 的新连接的事件循环, loops 不能被修改.
+	Next(loops []EventLoop, remoteAddr net.Addr) EventLoop
+}
+
+// newLoadBalancer 创建内置负载均衡策略的实现.
+func newLoadBalancer(lb LoadBalance) LoadBalancer {
+	switch lb {
+	case Random:
+		return new(randomLoadBalancer)
+	case LeastConnections:
+		return new(leastConnectionsLoadBalancer)
+	default:
+		return new(roundRobinLoadBalancer)
+	}
+}
+
+// roundRobinLoadBalancer .
+type roundRobinLoadBalancer struct {
+	nextLoopIndex uint32
+}
+
+// Next .
+func (lb *roundRobinLoadBalancer) Next(loops []EventLoop, _ net.Addr) EventLoop {
+	return loops[int(atomic.AddUint32(&lb.nextLoopIndex, 1)-1)%len(loops)]
+}
+
+// randomLoadBalancer .
+type randomLoadBalancer struct{}
+
+// Next .
+func (lb *randomLoadBalancer) Next(loops []EventLoop, _ net.Addr) EventLoop {
+	return loops[rand.Intn(len(loops))]
+}
+
+// leastConnectionsLoadBalancer .
+type leastConnectionsLoadBalancer struct{}
+
+// Next .
+func (lb *leastConnectionsLoadBalancer) Next(loops []EventLoop, _ net.Addr) (el EventLoop) {
+	el = loops[0]
+	least := el.Connections()
+	for _, loop := range loops[1:] {
+		if n := loop.Connections(); n < least {
+			el, least = loop, n
+		}
+	}
+	return
+}
+
 // IEventLoopGroup represents a set of event-loops.
 type IEventLoopGroup interface {
 	register(*eventloop)
-	next() *eventloop
+	next(net.Addr) (*eventloop, func())
 	iterate(func(int, *eventloop) bool)
 	len() int
 }
 
 // eventLoopGroup 事件循环组，仿netty
 type eventLoopGroup struct {
-	nextLoopIndex int
-	eventLoops    []*eventloop
-	size          int
+	lb         LoadBalancer
+	loops      []EventLoop
+	eventLoops []*eventloop
+	size       int
+	shared     bool                 // whether the group is an EventLoopGroup shared by several servers and clients
+	fallback   uint32               // round-robin index of the loops chosen when the load-balancer returns a foreign one
+	wg         sync.WaitGroup       // event-loop close WaitGroup
+	mu         sync.Mutex           // guards servers and stopped
+	servers    map[*server]struct{} // servers running on the group
+	attached   sync.WaitGroup       // done once all the servers have been shut down
+	stopped    bool                 // whether the group refuses new servers
+}
+
+// newEventLoopGroup .
+func newEventLoopGroup(lb LoadBalancer) *eventLoopGroup {
+	return &eventLoopGroup{lb: lb, servers: make(map[*server]struct{})}
 }
 
 // register .
 func (g *eventLoopGroup) register(el *eventloop) {
+	el.group = g
 	g.eventLoops = append(g.eventLoops, el)
+	g.loops = append(g.loops, el)
 	g.size++
 }
 
-// TODO: 支持更多负载均衡方式, 目前默认轮询
-func (g *eventLoopGroup) next() (el *eventloop) {
-	el = g.eventLoops[g.nextLoopIndex]
-	if g.nextLoopIndex++; g.nextLoopIndex >= g.size {
-		g.nextLoopIndex = 0
+// next 根据负载均衡策略为来自 remoteAddr 的新连接选择事件循环, 连接在选定时就被预先计入该事件循环,
+// 以免在它加入之前的突发连接都选中同一个事件循环. 连接加入事件循环或者交给它失败时需要调用返回的 release
+// 撤销预留, 多次调用只撤销一次; 事件循环退出后它的预留不再计入.
+func (g *eventLoopGroup) next(remoteAddr net.Addr) (*eventloop, func()) {
+	el := g.choose(remoteAddr)
+	atomic.AddInt32(&el.reserved, 1)
+	var released int32
+	return el, func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			atomic.AddInt32(&el.reserved, -1)
+		}
 	}
-	return
+}
+
+// choose 根据负载均衡策略选择事件循环, 负载均衡器返回的不是本组的事件循环时按它的序号选择,
+// 序号无效时退回到轮询.
+func (g *eventLoopGroup) choose(remoteAddr net.Addr) *eventloop {
+	loop := g.lb.Next(g.loops, remoteAddr)
+	if el, ok := loop.(*eventloop); ok && el.group == g {
+		return el
+	}
+	if loop != nil {
+		if idx := loop.Index(); idx >= 0 && idx < len(g.eventLoops) {
+			return g.eventLoops[idx]
+		}
+	}
+	return g.eventLoops[int(atomic.AddUint32(&g.fallback, 1)-1)%len(g.eventLoops)]
 }
 
 // iterate .
@@ -55,3 +160,156 @@ func (g *eventLoopGroup) iterate(f func(int, *eventloop) bool) {
 func (g *eventLoopGroup) len() int {
 	return g.size
 }
+
+// start runs the event-loops in the background.
+func (g *eventLoopGroup) start() {
+	for _, el := range g.eventLoops {
+		el := el
+		g.wg.Add(1)
+		go func() {
+			el.loopRun()
+			close(el.done)
+			g.wg.Done()
+		}()
+	}
+}
+
+// stop notifies the event-loops to exit and waits for them, then closes their pollers.
+func (g *eventLoopGroup) stop() {
+	for _, el := range g.eventLoops {
+		el := el
+		sniffError(el.poller.Trigger(func() error {
+			return ErrServerShutdown
+		}))
+	}
+	g.wg.Wait()
+	g.closePollers()
+}
+
+// closePollers .
+func (g *eventLoopGroup) closePollers() {
+	for _, el := range g.eventLoops {
+		_ = el.poller.Close()
+	}
+}
+
+// attach adds a server to the group, it fails once the group has been stopped.
+func (g *eventLoopGroup) attach(svr *server) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return ErrEventLoopGroupStopped
+	}
+	g.servers[svr] = struct{}{}
+	g.attached.Add(1)
+	return nil
+}
+
+// detach removes a server which has been shut down from the group.
+func (g *eventLoopGroup) detach(svr *server) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.servers[svr]; ok {
+		delete(g.servers, svr)
+		g.attached.Done()
+	}
+}
+
+// loopExit shuts down all the servers of the group once an event-loop has exited.
+func (g *eventLoopGroup) loopExit(idx int, err error) {
+	g.mu.Lock()
+	servers := make([]*server, 0, len(g.servers))
+	for svr := range g.servers {
+		servers = append(servers, svr)
+	}
+	g.mu.Unlock()
+	for _, svr := range servers {
+		svr.signalLoopExit(idx, err)
+	}
+}
+
+// numEventLoops returns the number of the event-loops to start with the options.
+func numEventLoops(options *Options) int {
+	numEventLoop := 1
+	if options.Multicore {
+		numEventLoop = runtime.NumCPU()
+	}
+	if options.NumEventLoop > 0 {
+		numEventLoop = options.NumEventLoop
+	}
+	return numEventLoop
+}
+
+// EventLoopGroup is a group of event-loops shared by several servers and clients set up with WithEventLoopGroup,
+// instead of each of them starting its own. The connections of all of them are distributed among the event-loops
+// by the load-balancer of the group. It is safe to use from any goroutine.
+type EventLoopGroup struct {
+	g    *eventLoopGroup
+	once sync.Once
+	done chan struct{}
+}
+
+// NewEventLoopGroup starts the event-loops of a group, Multicore, NumEventLoop, LB, LoadBalancer and Logger are
+// the options taken into account.
+func NewEventLoopGroup(opts ...Option) (*EventLoopGroup, error) {
+	options := loadOptions(opts...)
+	lb := options.LoadBalancer
+	if lb == nil {
+		lb = newLoadBalancer(options.LB)
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	g := newEventLoopGroup(lb)
+	g.shared = true
+	for i := 0; i < numEventLoops(options); i++ {
+		el, err := newEventLoop(i, logger)
+		if err != nil {
+			g.closePollers()
+			return nil, err
+		}
+		g.register(el)
+	}
+	g.start()
+	return &EventLoopGroup{g: g, done: make(chan struct{})}, nil
+}
+
+// Len returns the number of the event-loops of the group.
+func (g *EventLoopGroup) Len() int {
+	return g.g.len()
+}
+
+// Stop shuts down the servers and clients still running on the group, as ServerHandle.Stop does with ctx,
+// then stops the event-loops. It returns ctx.Err() if ctx is done before the group has been stopped completely.
+// The group can not be used by new servers and clients from then on.
+func (g *EventLoopGroup) Stop(ctx context.Context) error {
+	g.once.Do(func() {
+		g.g.mu.Lock()
+		g.g.stopped = true
+		servers := make([]*server, 0, len(g.g.servers))
+		for svr := range g.g.servers {
+			servers = append(servers, svr)
+		}
+		g.g.mu.Unlock()
+		for _, svr := range servers {
+			svr.signalShutdownContext(ctx)
+		}
+		go func() {
+			g.g.attached.Wait()
+			g.g.stop()
+			close(g.done)
+		}()
+	})
+	select {
+	case <-g.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the group has been stopped.
+func (g *EventLoopGroup) Done() <-chan struct{} {
+	return g.done
+}
@@ -0,0 +1,104 @@
+package netti
+
+import (
+	"net"
+	"testing"
+)
+
+type testEventLoop struct {
+	idx, connections int
+}
+
+func (el *testEventLoop) Index() int       { return el.idx }
+func (el *testEventLoop) Connections() int { return el.connections }
+
+func TestLoadBalancers(t *testing.T) {
+	loops := []EventLoop{
+		&testEventLoop{0, 3},
+		&testEventLoop{1, 1},
+		&testEventLoop{2, 2},
+	}
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+
+	lb := newLoadBalancer(RoundRobin)
+	for i := 0; i < 2*len(loops); i++ {
+		if el := lb.Next(loops, remoteAddr); el.Index() != i%len(loops) {
+			t.Fatalf("round-robin picked loop %d, want: %d", el.Index(), i%len(loops))
+		}
+	}
+
+	lb = newLoadBalancer(Random)
+	for i := 0; i < 100; i++ {
+		if el := lb.Next(loops, remoteAddr); el.Index() < 0 || el.Index() >= len(loops) {
+			t.Fatalf("random picked invalid loop %d", el.Index())
+		}
+	}
+
+	lb = newLoadBalancer(LeastConnections)
+	if el := lb.Next(loops, remoteAddr); el.Index() != 1 {
+		t.Fatalf("least-connections picked loop %d, want: 1", el.Index())
+	}
+	loops[1].(*testEventLoop).connections = 5
+	if el := lb.Next(loops, remoteAddr); el.Index() != 2 {
+		t.Fatalf("least-connections picked loop %d, want: 2", el.Index())
+	}
+}
+
+type testForeignLoadBalancer struct {
+	loop EventLoop
+}
+
+func (lb *testForeignLoadBalancer) Next(loops []EventLoop, remoteAddr net.Addr) EventLoop {
+	return lb.loop
+}
+
+func TestEventLoopGroupNext(t *testing.T) {
+	g := newEventLoopGroup(newLoadBalancer(LeastConnections))
+	for i := 0; i < 3; i++ {
+		g.register(&eventloop{idx: i})
+	}
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+
+	// The connections chosen before being added to the loops are spread among them.
+	var releases []func()
+	for i := 0; i < 6; i++ {
+		el, release := g.next(remoteAddr)
+		if el.idx != i%3 {
+			t.Fatalf("least-connections picked loop %d for connection %d, want: %d", el.idx, i, i%3)
+		}
+		releases = append(releases, release)
+	}
+
+	// A reservation is released only once however many times release is called.
+	releases[0]()
+	releases[0]()
+	if n := g.eventLoops[0].Connections(); n != 1 {
+		t.Fatalf("loop 0 has %d connections after a release, want: 1", n)
+	}
+	el, release := g.next(remoteAddr)
+	if el.idx != 0 {
+		t.Fatalf("least-connections picked loop %d, want: 0", el.idx)
+	}
+	release()
+
+	// The reservations of a loop which has exited are not counted.
+	g.eventLoops[1].done = make(chan struct{})
+	close(g.eventLoops[1].done)
+	if n := g.eventLoops[1].Connections(); n != 0 {
+		t.Fatalf("the exited loop 1 has %d connections, want: 0", n)
+	}
+
+	// The loops returned by a load-balancer but not of the group are mapped by their indexes if valid.
+	g.lb = &testForeignLoadBalancer{&testEventLoop{idx: 2}}
+	if el := g.choose(remoteAddr); el != g.eventLoops[2] {
+		t.Fatalf("a foreign loop should be mapped by its index, got loop %d", el.idx)
+	}
+	other := newEventLoopGroup(nil)
+	other.register(&eventloop{idx: 7})
+	for _, loop := range []EventLoop{&testEventLoop{idx: 3}, other.eventLoops[0], nil} {
+		g.lb = &testForeignLoadBalancer{loop}
+		if el := g.choose(remoteAddr); el.group != g {
+			t.Fatalf("a loop of another group was chosen for %v", loop)
+		}
+	}
+}
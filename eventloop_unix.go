@@ -5,35 +5,65 @@ package netti
 import (
 	"net"
 	"netti/internal/netpoll"
+	"netti/internal/timingwheel"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
 type eventloop struct {
-	idx          int             // 事件循环组中的唯一序号
-	svr          *server         // 时间循环中的服务器实例
-	codec        ICodec          // TCP数据包编解码器
-	packet       []byte          // read packet buffer
-	poller       *netpoll.Poller // epoll or iocp
-	connections  map[int]*conn   // loop connections fd -> conn
-	eventHandler EventHandler    // 事件回调处理接口
+	idx         int                    // 事件循环组中的唯一序号
+	group       *eventLoopGroup        // 事件循环所属的事件循环组, 主 reactor 为 nil
+	packet      []byte                 // read packet buffer
+	plaintext   []byte                 // buffer of the plaintext decrypted from the packet of a TLS connection
+	batches     map[int]*datagramBatch // buffers of the datagrams read and written in batches by size, nil until needed
+	batch       *datagramBatch         // the last batch read, whose replies are collected while it is reacted on
+	control     []byte                 // buffer of the control messages of a datagram read by itself, nil until needed
+	noMmsg      bool                   // whether recvmmsg is not supported, the datagrams are read one at a time then
+	poller      *netpoll.Poller        // epoll or iocp
+	listeners   map[int]*listener      // listeners polled by the loop fd -> listener
+	connections map[int]*conn          // loop connections fd -> conn
+	dialing     map[int]*conn          // connections being dialed fd -> conn
+	sessions    map[sessionKey]*conn   // UDP sessions listener fd and peer address -> conn
+	handshakes  int                    // number of the TLS handshake goroutines running for the loop
+	handshaking []*conn                // connections waiting for a TLS handshake goroutine, see startHandshake
+	connCount   int32                  // number of active connections, accessed atomically
+	reserved    int32                  // number of the connections assigned to the loop but not added yet, accessed atomically
+	wheel       *timingwheel.Wheel     // timers of the loop, driven by the poller
+	logger      Logger                 // customized logger for logging info
+	done        chan struct{}          // closed once the loop has exited
+}
+
+// newEventLoop creates an event-loop with the given index.
+func newEventLoop(idx int, logger Logger) (*eventloop, error) {
+	p, err := netpoll.NewPoller()
+	if err != nil {
+		return nil, err
+	}
+	return &eventloop{
+		idx:         idx,
+		poller:      p,
+		packet:      make([]byte, 0x10000),
+		listeners:   make(map[int]*listener),
+		connections: make(map[int]*conn),
+		dialing:     make(map[int]*conn),
+		sessions:    make(map[sessionKey]*conn),
+		wheel:       timingwheel.New(timerTick, timerWheelSize),
+		logger:      logger,
+		done:        make(chan struct{}),
+	}, nil
 }
 
 // loopRun .
 func (el *eventloop) loopRun() {
+	var err error
 	defer func() {
-		if el.idx == 0 && el.svr.opts.Ticker {
-			close(el.svr.ticktock)
-		}
-		el.svr.signalShutdown()
+		el.group.loopExit(el.idx, err)
 	}()
 
-	if el.idx == 0 && el.svr.opts.Ticker {
-		go el.loopTicker()
-	}
-
-	el.svr.logger.Printf("event-loop:%d exits with error: %v\n", el.idx, el.poller.Polling(el.handleEvent))
+	err = el.poller.Polling(el.handleEvent, el.wheel)
+	el.logger.Printf("event-loop:%d exits with error: %v\n", el.idx, err)
 }
 
 // handleEvent .
@@ -52,72 +82,225 @@ func (el *eventloop) handleEvent(fd int, ev uint32) error {
 			return nil
 		}
 	}
-	return el.loopAccept(fd)
+	if c, ok := el.dialing[fd]; ok {
+		return el.loopConnect(c)
+	}
+	if ln, ok := el.listeners[fd]; ok {
+		return el.loopAccept(ln)
+	}
+	return nil
 }
 
 // loopAccept .
-func (el *eventloop) loopAccept(fd int) error {
-	if fd == el.svr.ln.fd {
-		if el.svr.ln.pconn != nil {
-			return el.loopReadUDP(fd)
-		}
-		nfd, sa, err := unix.Accept(fd)
-		if err != nil {
-			if err == unix.EAGAIN {
-				return nil
-			}
-			return err
-		}
-		if err = unix.SetNonblock(nfd, true); err != nil {
-			return err
-		}
-		c := newTCPConn(nfd, el, sa)
-		if err = el.poller.AddRead(c.fd); err == nil {
-			el.connections[c.fd] = c
-			return el.loopOpen(c)
+func (el *eventloop) loopAccept(ln *listener) error {
+	if ln.pconn != nil {
+		return el.loopReadUDP(ln)
+	}
+	nfd, sa, err := unix.Accept(ln.fd)
+	if err != nil {
+		if err == unix.EAGAIN {
+			return nil
 		}
 		return err
 	}
-	return nil
+	if err = unix.SetNonblock(nfd, true); err != nil {
+		return err
+	}
+	ip, ok := ln.svr.admit(nfd, sa)
+	if !ok {
+		return nil
+	}
+	c := newTCPConn(nfd, el, sa, ln)
+	c.admitIP = ip
+	if err = el.poller.AddRead(c.fd); err == nil {
+		el.addConn(c)
+		return el.loopOpen(c)
+	}
+	_ = unix.Close(nfd)
+	ln.svr.release(ip)
+	return err
+}
+
+// Index .
+func (el *eventloop) Index() int {
+	return el.idx
+}
+
+// Connections .
+func (el *eventloop) Connections() int {
+	n := atomic.LoadInt32(&el.connCount)
+	select {
+	case <-el.done:
+		// The connections reserved on the loop which has exited are never added to it.
+	default:
+		n += atomic.LoadInt32(&el.reserved)
+	}
+	return int(n)
+}
+
+// dumpState logs the state of the loop, it must be called within the loop.
+func (el *eventloop) dumpState() {
+	var pending int
+	for _, c := range el.connections {
+		pending += c.outBuffer.Length()
+	}
+	el.logger.Printf("event-loop:%d listeners:%d connections:%d pending-write-bytes:%d\n",
+		el.idx, len(el.listeners), len(el.connections), pending)
+}
+
+// addConn registers the connection polled for reading to the loop.
+func (el *eventloop) addConn(c *conn) {
+	c.events = unix.EPOLLIN
+	el.connections[c.fd] = c
+	atomic.AddInt32(&el.connCount, 1)
+	atomic.AddInt32(&c.svr.connCount, 1)
+}
+
+// removeConn unregisters the connection from the loop.
+func (el *eventloop) removeConn(c *conn) {
+	delete(el.connections, c.fd)
+	atomic.AddInt32(&el.connCount, -1)
+	atomic.AddInt32(&c.svr.connCount, -1)
+	if c.ln != nil {
+		c.svr.release(c.admitIP)
+	}
 }
 
 // loopOpen .
 func (el *eventloop) loopOpen(c *conn) error {
+	keepAlive := c.svr.opts.TCPKeepAlive
+	if c.proxyWait != nil {
+		return el.loopWaitProxy(c)
+	}
+	if c.ln != nil {
+		keepAlive = c.ln.keepAlive
+		if c.localAddr == nil {
+			c.localAddr = c.ln.lnaddr
+		}
+	}
+	if c.remoteAddr == nil {
+		c.remoteAddr = netpoll.SockaddrToTCPOrUnixAddr(c.sa)
+	}
+	if config := c.tlsConfig(); config != nil && c.tls == nil {
+		return el.loopHandshake(c, config, nil)
+	}
 	c.opened = true
-	c.localAddr = el.svr.ln.lnaddr
-	c.remoteAddr = netpoll.SockaddrToTCPOrUnixAddr(c.sa)
-	out, action := el.eventHandler.OnOpened(c)
-	if el.svr.opts.TCPKeepAlive > 0 {
-		if _, ok := el.svr.ln.ln.(*net.TCPListener); ok {
-			_ = netpoll.SetKeepAlive(c.fd, int(el.svr.opts.TCPKeepAlive/time.Second))
+	el.watchIdle(c)
+	out, action := c.svr.eventHandler.OnOpened(c)
+	if keepAlive > 0 {
+		if _, ok := c.remoteAddr.(*net.TCPAddr); ok {
+			_ = netpoll.SetKeepAlive(c.fd, int(keepAlive/time.Second))
 		}
 	}
 	if out != nil {
-		c.open(out)
+		if c.open(out); !c.opened {
+			return nil // failed to seal
+		}
 	}
 
 	if !c.outBuffer.IsEmpty() {
-		_ = el.poller.AddWrite(c.fd)
+		_ = el.updateEvents(c)
 	}
 
 	return el.handleAction(c, action)
 }
 
+// watchIdle sets up the idle checks and the first frame deadline of a newly opened connection.
+func (el *eventloop) watchIdle(c *conn) {
+	opts := c.svr.opts
+	c.lastRead = time.Now()
+	c.lastWrite = c.lastRead
+	if opts.ReaderIdleTimeout > 0 {
+		el.scheduleIdle(c, ReaderIdle, opts.ReaderIdleTimeout, opts.ReaderIdleTimeout)
+	}
+	if opts.WriterIdleTimeout > 0 {
+		el.scheduleIdle(c, WriterIdle, opts.WriterIdleTimeout, opts.WriterIdleTimeout)
+	}
+	if opts.AllIdleTimeout > 0 {
+		el.scheduleIdle(c, AllIdle, opts.AllIdleTimeout, opts.AllIdleTimeout)
+	}
+	if opts.FirstFrameTimeout > 0 {
+		var t *timingwheel.Timer
+		t = timingwheel.NewTimer(opts.FirstFrameTimeout, false, func() error {
+			delete(c.timers, t)
+			if c.opened && c.frames == 0 {
+				return el.loopCloseConn(c, ErrFirstFrameTimeout)
+			}
+			return nil
+		})
+		c.addTimer(t)
+	}
+}
+
+// scheduleIdle checks the idleness of the connection after delay, OnIdle fires if the connection has been
+// idle for timeout by then, otherwise the check is deferred until timeout after the last activity.
+func (el *eventloop) scheduleIdle(c *conn, kind IdleKind, timeout, delay time.Duration) {
+	var t *timingwheel.Timer
+	t = timingwheel.NewTimer(delay, false, func() error {
+		delete(c.timers, t)
+		if !c.opened {
+			return nil
+		}
+		last := c.lastRead
+		switch kind {
+		case WriterIdle:
+			last = c.lastWrite
+		case AllIdle:
+			if c.lastWrite.After(last) {
+				last = c.lastWrite
+			}
+		}
+		if d := timeout - time.Since(last); d > 0 {
+			el.scheduleIdle(c, kind, timeout, d)
+			return nil
+		}
+		el.scheduleIdle(c, kind, timeout, timeout)
+		return el.handleAction(c, c.svr.eventHandler.OnIdle(c, kind))
+	})
+	c.addTimer(t)
+}
+
 // loopRead .
 func (el *eventloop) loopRead(c *conn) error {
-	n, err := unix.Read(c.fd, el.packet)
+	buf := el.packet
+	now := time.Now()
+	if c.readLimit != nil || c.svr.readLimit != nil {
+		q := quota(len(buf), now, c.readLimit, c.svr.readLimit)
+		if q == 0 {
+			return el.pauseRead(c, now)
+		}
+		buf = buf[:q]
+	}
+	n, err := unix.Read(c.fd, buf)
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if err == nil && c.tls != nil && !c.opened {
+			return el.loopHandshakeEOF(c)
+		}
 		return el.loopCloseConn(c, err)
 	}
+	consume(n, c.readLimit, c.svr.readLimit)
+	c.lastRead = now
+	if c.proxyWait != nil {
+		return el.loopReadProxy(c, el.packet[:n])
+	}
+	if c.tls != nil {
+		return el.loopReadTLS(c, el.packet[:n])
+	}
 	c.buffer = el.packet[:n]
+	return el.loopReact(c)
+}
 
+// loopReact decodes the frames from the data read into c.buffer and fires React on each of them,
+// the rest of the data is kept in the inbound buffer.
+func (el *eventloop) loopReact(c *conn) error {
 	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
-		out, action := el.eventHandler.React(inFrame, c)
+		c.frames++
+		out, action := c.svr.eventHandler.React(inFrame, c)
 		if out != nil {
-			outFrame, _ := el.codec.Encode(c, out)
+			outFrame, _ := c.codec.Encode(c, out)
 			c.write(outFrame)
 		}
 		switch action {
@@ -127,7 +310,7 @@ func (el *eventloop) loopRead(c *conn) error {
 			return el.loopCloseConn(c, nil)
 		case Shutdown:
 			_ = el.loopWrite(c)
-			return ErrServerShutdown
+			return el.shutdown(c.svr)
 		}
 		if !c.opened {
 			return nil
@@ -142,6 +325,19 @@ func (el *eventloop) loopRead(c *conn) error {
 func (el *eventloop) loopWrite(c *conn) error {
 	// todo 使用环形缓冲区避免写拷贝，扩容问题可以使用环形压缩链表解决
 	head, tail := c.outBuffer.LazyReadAll()
+	shaped := c.shaped()
+	if shaped && len(head) > 0 {
+		now := time.Now()
+		q := quota(len(head)+len(tail), now, c.writeLimit, c.svr.writeLimit)
+		if q == 0 {
+			return el.pauseWrite(c, now)
+		}
+		if q <= len(head) {
+			head, tail = head[:q], nil
+		} else if q < len(head)+len(tail) {
+			tail = tail[:q-len(head)]
+		}
+	}
 	n, err := unix.Write(c.fd, head)
 	if err != nil {
 		if err == unix.EAGAIN {
@@ -150,6 +346,10 @@ func (el *eventloop) loopWrite(c *conn) error {
 		return el.loopCloseConn(c, err)
 	}
 	c.outBuffer.Shift(n)
+	c.lastWrite = time.Now()
+	if shaped {
+		consume(n, c.writeLimit, c.svr.writeLimit)
+	}
 
 	if len(head) == n && tail != nil {
 		n, err = unix.Write(c.fd, tail)
@@ -160,31 +360,123 @@ func (el *eventloop) loopWrite(c *conn) error {
 			return el.loopCloseConn(c, err)
 		}
 		c.outBuffer.Shift(n)
+		if shaped {
+			consume(n, c.writeLimit, c.svr.writeLimit)
+		}
 	}
 
-	if c.outBuffer.IsEmpty() {
-		_ = el.poller.ModRead(c.fd)
+	return el.updateEvents(c)
+}
+
+// updateEvents polls the connection for the events it is interested in, reading unless the reads are paused or
+// the writes are paused with data pending, and writing if there is data pending and the writes are not paused.
+// The connection is removed from the poller while it is interested in neither.
+func (el *eventloop) updateEvents(c *conn) (err error) {
+	atomic.StoreInt32(&c.pending, int32(c.outBuffer.Length()))
+	pending := !c.outBuffer.IsEmpty()
+	var events uint32
+	if !c.readPaused && !(pending && c.writePaused) {
+		events |= unix.EPOLLIN
 	}
-	return nil
+	if pending && !c.writePaused {
+		events |= unix.EPOLLOUT
+	}
+	if events == c.events {
+		return nil
+	}
+	switch {
+	case events == 0:
+		err = el.poller.Delete(c.fd)
+	case c.events == 0 && events == unix.EPOLLIN:
+		err = el.poller.AddRead(c.fd)
+	case c.events == 0 && events == unix.EPOLLOUT:
+		err = el.poller.AddWrite(c.fd)
+	case c.events == 0:
+		err = el.poller.AddReadWrite(c.fd)
+	case events == unix.EPOLLIN:
+		err = el.poller.ModRead(c.fd)
+	case events == unix.EPOLLOUT:
+		err = el.poller.ModWrite(c.fd)
+	default:
+		err = el.poller.ModReadWrite(c.fd)
+	}
+	if err == nil {
+		c.events = events
+	}
+	return
+}
+
+// pauseRead stops reading the connection until the read limiters are refilled.
+func (el *eventloop) pauseRead(c *conn, now time.Time) error {
+	if c.readPaused {
+		return nil
+	}
+	c.readPaused = true
+	el.resumeLater(c, refillWait(now, c.readLimit, c.svr.readLimit), func() { c.readPaused = false })
+	return el.updateEvents(c)
+}
+
+// pauseWrite stops writing the connection until the write limiters are refilled.
+func (el *eventloop) pauseWrite(c *conn, now time.Time) error {
+	if c.writePaused {
+		return nil
+	}
+	c.writePaused = true
+	el.resumeLater(c, refillWait(now, c.writeLimit, c.svr.writeLimit), func() { c.writePaused = false })
+	return el.updateEvents(c)
+}
+
+// resumeLater invokes resume and updates the events of the connection after d.
+func (el *eventloop) resumeLater(c *conn, d time.Duration, resume func()) {
+	var t *timingwheel.Timer
+	t = timingwheel.NewTimer(d, false, func() error {
+		delete(c.timers, t)
+		if !c.opened {
+			return nil
+		}
+		resume()
+		return el.updateEvents(c)
+	})
+	c.addTimer(t)
 }
 
 // loopCloseConn .
 func (el *eventloop) loopCloseConn(c *conn, err error) error {
+	if c.session != nil {
+		return el.loopCloseSession(c, err)
+	}
+	if c.tls != nil && c.outBuffer.IsEmpty() {
+		// Tell the peer that the data has not been truncated, unless some is left unsent.
+		c.tls.closeNotify(c.fd)
+	}
 	// todo 可能导致一处内存泄露
-	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
+	var err0 error
+	if c.events != 0 {
+		err0 = el.poller.Delete(c.fd)
+	}
+	err1 := unix.Close(c.fd)
 	if err0 == nil && err1 == nil {
-		delete(el.connections, c.fd)
-		switch el.eventHandler.OnClosed(c, err) {
+		el.removeConn(c)
+		c.stopTimers()
+		if c.tls != nil {
+			c.tls.close(err)
+		}
+		if !c.opened {
+			// Closed during the TLS handshake, before OnOpened.
+			c.releaseTCP()
+			return nil
+		}
+		switch c.svr.eventHandler.OnClosed(c, err) {
 		case Shutdown:
-			return ErrServerShutdown
+			return el.shutdown(c.svr)
 		}
 		c.releaseTCP()
 	} else {
 		if err0 != nil {
-			el.svr.logger.Printf("failed to delete fd:%d from poller, error:%v\n", c.fd, err0)
+			el.logger.Printf("failed to delete fd:%d from poller, error:%v\n", c.fd, err0)
 		}
 		if err1 != nil {
-			el.svr.logger.Printf("failed to close fd:%d, error:%v\n", c.fd, err1)
+			el.logger.Printf("failed to close fd:%d, error:%v\n", c.fd, err1)
 		}
 	}
 	return nil
@@ -195,46 +487,71 @@ func (el *eventloop) loopWake(c *conn) error {
 	//if co, ok := el.connections[c.fd]; !ok || co != c {
 	//	return nil // ignore stale wakes.
 	//}
-	out, action := el.eventHandler.React(nil, c)
+	out, action := c.svr.eventHandler.React(nil, c)
 	if out != nil {
-		frame, _ := el.codec.Encode(c, out)
+		frame, _ := c.codec.Encode(c, out)
 		c.write(frame)
 	}
 	return el.handleAction(c, action)
 }
 
-// loopTicker .
-func (el *eventloop) loopTicker() {
-	var (
-		delay time.Duration
-		open  bool
-		err   error
-	)
-	for {
-		err = el.poller.Trigger(func() (err error) {
-			delay, action := el.eventHandler.Tick()
-			el.svr.ticktock <- delay
-			switch action {
-			case None:
-			case Shutdown:
-				err = ErrServerShutdown
-			}
-			return
-		})
-		if err != nil {
-			el.svr.logger.Printf("failed to awake poller with error:%v, stopping ticker\n", err)
-			break
+// loopTick runs the Tick event of the server and schedules the next one on the wheel,
+// until the server has been detached from the loop.
+func (el *eventloop) loopTick(svr *server) error {
+	if atomic.LoadInt32(&svr.detached) != 0 {
+		return nil
+	}
+	delay, action := svr.eventHandler.Tick()
+	if action == Shutdown {
+		return el.shutdown(svr)
+	}
+	el.wheel.AfterFunc(delay, func() error { return el.loopTick(svr) })
+	return nil
+}
+
+// shutdown handles a Shutdown action returned by the event handler of the server: the loop exits if it is owned
+// by the server, while a loop of a shared group keeps serving the others and only the server is shut down.
+func (el *eventloop) shutdown(svr *server) error {
+	if !el.group.shared {
+		return ErrServerShutdown
+	}
+	svr.signalLoopExit(el.idx, ErrServerShutdown)
+	return nil
+}
+
+// detach closes the connections and removes the listeners of the server from the loop.
+func (el *eventloop) detach(svr *server) {
+	for fd, ln := range el.listeners {
+		if ln.svr == svr {
+			_ = el.poller.Delete(fd)
+			delete(el.listeners, fd)
 		}
-		if delay, open = <-el.svr.ticktock; open {
-			time.Sleep(delay)
-		} else {
-			break
+	}
+	for _, c := range el.connections {
+		if c.svr != svr {
+			continue
+		}
+		// Flush the pending replies as far as the socket takes them, writing may close the connection.
+		if !c.outBuffer.IsEmpty() {
+			_ = el.loopWrite(c)
+		}
+		if el.connections[c.fd] == c {
+			sniffError(el.loopCloseConn(c, ErrServerShutdown))
 		}
 	}
+	for _, c := range el.dialing {
+		if c.svr == svr {
+			el.failDial(c, ErrServerShutdown)
+		}
+	}
+	el.closeSessions(func(c *conn) bool { return c.svr == svr }, ErrServerShutdown)
 }
 
 // handleAction .
 func (el *eventloop) handleAction(c *conn, action Action) error {
+	if c.session != nil {
+		return el.handleSessionAction(c, action)
+	}
 	switch action {
 	case None:
 		return nil
@@ -243,29 +560,51 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 		return el.loopCloseConn(c, nil)
 	case Shutdown:
 		_ = el.loopWrite(c)
-		return ErrServerShutdown
+		return el.shutdown(c.svr)
 	default:
 		return nil
 	}
 }
 
 // loopReadUDP .
-func (el *eventloop) loopReadUDP(fd int) error {
-	n, sa, err := unix.Recvfrom(fd, el.packet, 0)
+func (el *eventloop) loopReadUDP(ln *listener) error {
+	if b := el.datagramBatch(ln); b != nil {
+		return el.loopReadUDPBatch(ln, b)
+	}
+	if ln.gro {
+		return el.loopReadGRO(ln)
+	}
+	n, sa, err := unix.Recvfrom(ln.fd, el.packet, 0)
 	if err != nil || n == 0 {
 		if err != nil && err != unix.EAGAIN {
-			el.svr.logger.Printf("failed to read UPD packet from fd:%d, error:%v\n", fd, err)
+			el.logger.Printf("failed to read UPD packet from fd:%d, error:%v\n", ln.fd, err)
 		}
 		return nil
 	}
-	c := newUDPConn(fd, el, sa)
-	out, action := el.eventHandler.React(el.packet[:n], c)
+	return el.loopDatagram(ln, sa, el.packet[:n])
+}
+
+// loopDatagram reacts on a datagram read from the listener.
+func (el *eventloop) loopDatagram(ln *listener, sa unix.Sockaddr, data []byte) error {
+	var header *ProxyHeader
+	if ln.proxyMode != ProxyProtocolOff {
+		var ok bool
+		if header, data, ok = ln.readProxyUDP(data); !ok {
+			return nil
+		}
+	}
+	if ln.sessionIdle > 0 {
+		return el.loopReadSession(ln, sa, header, data)
+	}
+	c := newUDPConn(el, sa, ln)
+	c.setProxyHeader(header)
+	out, action := c.svr.eventHandler.React(data, c)
 	if out != nil {
-		_ = c.sendTo(out)
+		_ = el.sendDatagram(c.fd, c.sa, out)
 	}
 	switch action {
 	case Shutdown:
-		return ErrServerShutdown
+		return el.shutdown(c.svr)
 	}
 	c.releaseUDP()
 	return nil
@@ -0,0 +1,207 @@
+package netti
+
+import (
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AcceptFilter decides whether a newly accepted connection is allowed before it is opened, the rejected
+// ones are closed without firing any event. ip is nil for the connections of unix sockets.
+// The filters are invoked concurrently by the event-loops accepting connections.
+type AcceptFilter interface {
+	Accept(ip net.IP) bool
+}
+
+// AcceptFilterFunc is an adapter to use an ordinary function as an AcceptFilter.
+type AcceptFilterFunc func(ip net.IP) bool
+
+// Accept calls f(ip).
+func (f AcceptFilterFunc) Accept(ip net.IP) bool {
+	return f(ip)
+}
+
+// cidrFilter allows the IPs in the allow list, if any, except the ones in the deny list.
+type cidrFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewCIDRFilter creates a filter which rejects the connections from the networks in deny, and if allow is not
+// empty, the ones from outside of allow. The networks are in CIDR notation, e.g. "192.0.2.0/24" or "2001:db8::/32",
+// a single IP stands for the network of itself. The connections of unix sockets are always allowed.
+func NewCIDRFilter(allow, deny []string) (AcceptFilter, error) {
+	f := new(cidrFilter)
+	var err error
+	if f.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept .
+func (f *cidrFilter) Accept(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// tokenBucket holds up to burst tokens and refills rate tokens per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket until now and takes a token if there is one.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		b.last = now
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+}
+
+// rateLimitFilter limits the rate of the connections, globally or per source prefix.
+type rateLimitFilter struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      int
+	global     *tokenBucket
+	v4Mask     net.IPMask
+	v6Mask     net.IPMask
+	buckets    map[string]*list.Element // per prefix buckets, the elements of lru
+	lru        *list.List               // *prefixBucket, the most recently used first
+	maxBuckets int
+	overflow   *tokenBucket // shared by the prefixes without a bucket of their own once the table is full
+	now        func() time.Time
+}
+
+// prefixBucket is the bucket of a source prefix.
+type prefixBucket struct {
+	tokenBucket
+	prefix string
+}
+
+// maxRateLimitBuckets is the number of the source prefixes tracked with buckets of their own.
+const maxRateLimitBuckets = 4096
+
+// NewRateLimitFilter creates a filter which allows rate connections per second with bursts of up to burst
+// connections, it applies to all the connections of the server.
+func NewRateLimitFilter(rate float64, burst int) AcceptFilter {
+	return &rateLimitFilter{
+		rate:   rate,
+		burst:  burst,
+		global: &tokenBucket{tokens: float64(burst), last: time.Now()},
+		now:    time.Now,
+	}
+}
+
+// NewPrefixRateLimitFilter creates a filter which allows rate connections per second with bursts of up to burst
+// connections from each source network, that is an IPv4 address masked to v4Bits or an IPv6 address masked
+// to v6Bits, e.g. 24 and 64. The connections of unix sockets are not limited.
+// Up to 4096 networks are tracked, the least recently seen one is forgotten for a new one once its bucket
+// has been refilled, otherwise the new ones share a single bucket until then.
+func NewPrefixRateLimitFilter(rate float64, burst int, v4Bits, v6Bits int) AcceptFilter {
+	now := time.Now()
+	return &rateLimitFilter{
+		rate:       rate,
+		burst:      burst,
+		v4Mask:     net.CIDRMask(v4Bits, 8*net.IPv4len),
+		v6Mask:     net.CIDRMask(v6Bits, 8*net.IPv6len),
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxBuckets: maxRateLimitBuckets,
+		overflow:   &tokenBucket{tokens: float64(burst), last: now},
+		now:        time.Now,
+	}
+}
+
+// Accept .
+func (f *rateLimitFilter) Accept(ip net.IP) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if f.global != nil {
+		return f.global.take(now, f.rate, f.burst)
+	}
+	if ip == nil {
+		return true
+	}
+	var prefix string
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix = ip4.Mask(f.v4Mask).String()
+	} else {
+		prefix = ip.Mask(f.v6Mask).String()
+	}
+	if e, ok := f.buckets[prefix]; ok {
+		f.lru.MoveToFront(e)
+		return e.Value.(*prefixBucket).take(now, f.rate, f.burst)
+	}
+	if f.lru.Len() >= f.maxBuckets {
+		// Forget the least recently seen prefix only if its bucket is the same as a new one,
+		// so that the limit can not be reset by flooding with other prefixes.
+		e := f.lru.Back()
+		b := e.Value.(*prefixBucket)
+		if b.refill(now, f.rate, f.burst); b.tokens < float64(f.burst) {
+			return f.overflow.take(now, f.rate, f.burst)
+		}
+		delete(f.buckets, b.prefix)
+		f.lru.Remove(e)
+	}
+	b := &prefixBucket{tokenBucket: tokenBucket{tokens: float64(f.burst), last: now}, prefix: prefix}
+	f.buckets[prefix] = f.lru.PushFront(b)
+	return b.take(now, f.rate, f.burst)
+}
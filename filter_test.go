@@ -0,0 +1,83 @@
+package netti
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	f, err := NewCIDRFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16", "10.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.0.0.1":        true,
+		"10.1.2.3":        false,
+		"10.2.3.4":        false,
+		"10.2.3.5":        true,
+		"192.0.2.1":       false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::ffff:10.0.0.1": true,
+	} {
+		if got := f.Accept(net.ParseIP(ip)); got != want {
+			t.Errorf("Accept(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if !f.Accept(nil) {
+		t.Error("unix sockets should be accepted")
+	}
+	if _, err = NewCIDRFilter(nil, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR should fail")
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	f := NewRateLimitFilter(10, 2).(*rateLimitFilter)
+	f.now = func() time.Time { return now }
+	f.global.last = now
+	ip := net.ParseIP("192.0.2.1")
+	if !f.Accept(ip) || !f.Accept(nil) || f.Accept(ip) {
+		t.Fatal("the burst should be allowed and no more")
+	}
+	now = now.Add(100 * time.Millisecond)
+	if !f.Accept(ip) || f.Accept(ip) {
+		t.Fatal("a token should be refilled after 100ms")
+	}
+
+	f = NewPrefixRateLimitFilter(1, 1, 24, 64).(*rateLimitFilter)
+	f.now = func() time.Time { return now }
+	if !f.Accept(net.ParseIP("192.0.2.1")) || f.Accept(net.ParseIP("192.0.2.2")) {
+		t.Fatal("the addresses of the same prefix should share the bucket")
+	}
+	if !f.Accept(net.ParseIP("192.0.3.1")) || !f.Accept(net.ParseIP("2001:db8::1")) ||
+		f.Accept(net.ParseIP("2001:db8::2")) {
+		t.Fatal("the addresses of the different prefixes should not share the bucket")
+	}
+
+	f = NewPrefixRateLimitFilter(1, 1, 32, 128).(*rateLimitFilter)
+	f.now = func() time.Time { return now }
+	f.overflow.last = now
+	f.maxBuckets = 2
+	if !f.Accept(net.ParseIP("192.0.2.1")) || !f.Accept(net.ParseIP("192.0.2.2")) {
+		t.Fatal("the first prefixes should have buckets of their own")
+	}
+	if !f.Accept(net.ParseIP("192.0.2.3")) || f.Accept(net.ParseIP("192.0.2.4")) || f.Accept(net.ParseIP("192.0.2.1")) {
+		t.Fatal("the new prefixes should share the overflow bucket while the table is full of busy ones")
+	}
+	if f.lru.Len() != 2 || len(f.buckets) != 2 {
+		t.Fatalf("the table should be bounded, %d buckets", len(f.buckets))
+	}
+	now = now.Add(time.Second)
+	if !f.Accept(net.ParseIP("192.0.2.5")) || f.Accept(net.ParseIP("192.0.2.5")) {
+		t.Fatal("the least recently seen prefix should be replaced once its bucket is refilled")
+	}
+	if _, ok := f.buckets["192.0.2.2"]; ok {
+		t.Fatal("the least recently seen prefix should be forgotten")
+	}
+	if _, ok := f.buckets["192.0.2.1"]; !ok {
+		t.Fatal("the recently seen prefix should be kept")
+	}
+}
@@ -3,8 +3,9 @@
 package netpoll
 
 import (
+	"os"
+
 	"golang.org/x/sys/unix"
-	"log"
 )
 
 // Poller poller 负责监控文件描述符.
@@ -55,15 +56,32 @@ func (p *Poller) Trigger(task Task) error {
 	return nil
 }
 
-// Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
+// Timer is driven by the poller, it bounds the time spent waiting for network-events
+// and runs the expired timers after each of the waits.
+type Timer interface {
+	// Timeout returns the milliseconds to wait for the next expiration, -1 if there is none.
+	Timeout() int
+	// Expire runs the timers expired so far.
+	Expire() error
+}
+
+// Polling blocks the current goroutine, waiting for network-events, until callback, a task or a timer returns
+// an error, or a fatal error occurs while waiting, e.g. EBADF after the poller has been closed.
+// timer may be nil if there is no timer to drive.
+func (p *Poller) Polling(callback func(fd int, ev uint32) error, timer Timer) (err error) {
 	el := newEventList(InitEvents)
 	var wakenUp bool
 	for {
-		n, err0 := unix.EpollWait(p.fd, el.events, -1)
-		if err0 != nil && err0 != unix.EINTR {
-			log.Println(err0)
-			continue
+		msec := -1
+		if timer != nil {
+			msec = timer.Timeout()
+		}
+		n, err0 := unix.EpollWait(p.fd, el.events, msec)
+		if err0 != nil {
+			if err0 == unix.EINTR {
+				continue
+			}
+			return os.NewSyscallError("epoll_wait", err0)
 		}
 		for i := 0; i < n; i++ {
 			if fd := int(el.events[i].Fd); fd != p.wfd {
@@ -81,6 +99,11 @@ func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
 				return
 			}
 		}
+		if timer != nil {
+			if err = timer.Expire(); err != nil {
+				return
+			}
+		}
 		if n == el.size {
 			el.increase()
 		}
@@ -129,6 +152,15 @@ func (p *Poller) ModRead(fd int) error {
 	)
 }
 
+// ModWrite ...
+func (p *Poller) ModWrite(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd,
+		&unix.EpollEvent{Fd: int32(fd),
+			Events: unix.EPOLLOUT,
+		},
+	)
+}
+
 // ModReadWrite ...
 func (p *Poller) ModReadWrite(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd,
@@ -0,0 +1,23 @@
+// +build linux
+
+package netpoll
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPollingFatalError(t *testing.T) {
+	p, err := NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Polling(func(fd int, ev uint32) error { return nil }, nil); !errors.Is(err, unix.EBADF) {
+		t.Fatalf("polling on a closed poller should fail with EBADF, got: %v", err)
+	}
+}
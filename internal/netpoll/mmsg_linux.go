@@ -0,0 +1,153 @@
+// +build linux
+
+package netpoll
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr of recvmmsg(2) and sendmmsg(2).
+type mmsghdr struct {
+	Hdr unix.Msghdr
+	Len uint32
+}
+
+// MsgBatch holds the message headers for reading or writing a batch of datagrams in one system call,
+// it is reused across the calls and must not be used concurrently.
+type MsgBatch struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+	oobs  [][]byte // control buffers of the datagrams read, nil if the control messages are not read
+}
+
+// NewMsgBatch creates the message headers for batches of up to n datagrams.
+func NewMsgBatch(n int) *MsgBatch {
+	return &MsgBatch{
+		hdrs:  make([]mmsghdr, n),
+		iovs:  make([]unix.Iovec, n),
+		names: make([]unix.RawSockaddrAny, n),
+	}
+}
+
+// EnableControl reads the control messages of the datagrams, up to size bytes each, see Control.
+func (b *MsgBatch) EnableControl(size int) {
+	mem := make([]byte, len(b.hdrs)*size)
+	b.oobs = make([][]byte, len(b.hdrs))
+	for i := range b.oobs {
+		b.oobs[i] = mem[i*size : (i+1)*size : (i+1)*size]
+	}
+}
+
+// Control returns the control messages of the i-th datagram read by the last Recvmmsg.
+func (b *MsgBatch) Control(i int) []byte {
+	if b.oobs == nil {
+		return nil
+	}
+	return b.oobs[i][:b.hdrs[i].Hdr.Controllen]
+}
+
+// Recvmmsg reads up to len(bufs) datagrams from fd, the i-th datagram is read into bufs[i] with its length in ns[i]
+// and its source address in addrs[i]. It returns the number of datagrams read.
+func (b *MsgBatch) Recvmmsg(fd int, bufs [][]byte, ns []int, addrs []unix.Sockaddr) (int, error) {
+	n := len(bufs)
+	if n > len(b.hdrs) {
+		n = len(b.hdrs)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	for i := 0; i < n; i++ {
+		b.iovs[i].Base = &bufs[i][0]
+		b.iovs[i].SetLen(len(bufs[i]))
+		b.hdrs[i] = mmsghdr{}
+		h := &b.hdrs[i].Hdr
+		h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		h.Namelen = unix.SizeofSockaddrAny
+		h.Iov = &b.iovs[i]
+		h.SetIovlen(1)
+		if b.oobs != nil {
+			h.Control = &b.oobs[i][0]
+			h.SetControllen(len(b.oobs[i]))
+		}
+	}
+	r, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(n),
+		0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	for i := 0; i < int(r); i++ {
+		ns[i] = int(b.hdrs[i].Len)
+		addrs[i] = rawToSockaddr(&b.names[i])
+	}
+	return int(r), nil
+}
+
+// Sendmmsg writes bufs[i] as a datagram to addrs[i] through fd, up to the size of the batch.
+// It returns the number of datagrams written, the error is that of the first datagram if none has been written.
+func (b *MsgBatch) Sendmmsg(fd int, bufs [][]byte, addrs []unix.Sockaddr) (int, error) {
+	n := len(bufs)
+	if n > len(b.hdrs) {
+		n = len(b.hdrs)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	for i := 0; i < n; i++ {
+		b.hdrs[i] = mmsghdr{}
+		h := &b.hdrs[i].Hdr
+		if len(bufs[i]) > 0 {
+			b.iovs[i].Base = &bufs[i][0]
+			b.iovs[i].SetLen(len(bufs[i]))
+			h.Iov = &b.iovs[i]
+			h.SetIovlen(1)
+		}
+		if l := sockaddrToRaw(addrs[i], &b.names[i]); l > 0 {
+			h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+			h.Namelen = l
+		}
+	}
+	r, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(n),
+		0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// rawToSockaddr converts the source address of a datagram, it returns nil for the families other than IPv4 and IPv6.
+func rawToSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &unix.SockaddrInet4{Port: int(p[0])<<8 + int(p[1]), Addr: pp.Addr}
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &unix.SockaddrInet6{Port: int(p[0])<<8 + int(p[1]), ZoneId: pp.Scope_id, Addr: pp.Addr}
+	}
+	return nil
+}
+
+// sockaddrToRaw converts the destination address of a datagram, it returns the length of the raw address,
+// zero for the families other than IPv4 and IPv6.
+func sockaddrToRaw(sa unix.Sockaddr, rsa *unix.RawSockaddrAny) uint32 {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		*pp = unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: sa.Addr}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		return unix.SizeofSockaddrInet4
+	case *unix.SockaddrInet6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		*pp = unix.RawSockaddrInet6{Family: unix.AF_INET6, Scope_id: sa.ZoneId, Addr: sa.Addr}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		return unix.SizeofSockaddrInet6
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+// +build linux
+
+package netpoll
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMsgBatch(t *testing.T) {
+	for _, tc := range []struct {
+		family int
+		addr   unix.Sockaddr
+	}{
+		{unix.AF_INET, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}},
+		{unix.AF_INET6, &unix.SockaddrInet6{Addr: [16]byte{15: 1}}},
+	} {
+		bind := func() (int, unix.Sockaddr) {
+			fd, err := unix.Socket(tc.family, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0)
+			if err != nil {
+				t.Skipf("unsupported family %d: %v", tc.family, err)
+			}
+			if err = unix.Bind(fd, tc.addr); err != nil {
+				_ = unix.Close(fd)
+				t.Skipf("failed to bind family %d: %v", tc.family, err)
+			}
+			sa, _ := unix.Getsockname(fd)
+			return fd, sa
+		}
+		rfd, raddr := bind()
+		defer unix.Close(rfd)
+		sfd, saddr := bind()
+		defer unix.Close(sfd)
+
+		b := NewMsgBatch(4)
+		msgs := [][]byte{[]byte("a"), []byte("bb"), {}, []byte("dddd"), []byte("eeeee")}
+		to := []unix.Sockaddr{raddr, raddr, raddr, raddr, raddr}
+		n, err := b.Sendmmsg(sfd, msgs, to)
+		if err != nil || n != 4 {
+			t.Fatalf("sendmmsg: %d, %v", n, err)
+		}
+
+		bufs := [][]byte{make([]byte, 8), make([]byte, 8), make([]byte, 8), make([]byte, 8), make([]byte, 8)}
+		lens := make([]int, 5)
+		addrs := make([]unix.Sockaddr, 5)
+		if n, err = b.Recvmmsg(rfd, bufs, lens, addrs); err != nil || n != 4 {
+			t.Fatalf("recvmmsg: %d, %v", n, err)
+		}
+		for i := 0; i < n; i++ {
+			if string(bufs[i][:lens[i]]) != string(msgs[i]) {
+				t.Fatalf("datagram %d: %q, want %q", i, bufs[i][:lens[i]], msgs[i])
+			}
+			switch from := addrs[i].(type) {
+			case *unix.SockaddrInet4:
+				if want := saddr.(*unix.SockaddrInet4); from.Port != want.Port || from.Addr != want.Addr {
+					t.Fatalf("unexpected source %v, want %v", from, want)
+				}
+			case *unix.SockaddrInet6:
+				if want := saddr.(*unix.SockaddrInet6); from.Port != want.Port || from.Addr != want.Addr {
+					t.Fatalf("unexpected source %v, want %v", from, want)
+				}
+			default:
+				t.Fatalf("unexpected source %T", from)
+			}
+		}
+		if _, err = b.Recvmmsg(rfd, bufs, lens, addrs); err != unix.EAGAIN {
+			t.Fatalf("the socket should be drained, got: %v", err)
+		}
+	}
+}
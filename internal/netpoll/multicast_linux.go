@@ -0,0 +1,128 @@
+// +build linux
+
+package netpoll
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sizeofSockaddrStorage is the size of struct sockaddr_storage, which is aligned as a pointer.
+const sizeofSockaddrStorage = 128
+
+// JoinGroup joins the UDP socket to the multicast group on the interface with the given index, zero for the one
+// chosen by the kernel. The datagrams are restricted to those sent by source if it is not nil, i.e. source-specific
+// multicast.
+func JoinGroup(fd, ifindex int, group, source net.IP) error {
+	if source != nil {
+		return setGroupReq(fd, unix.MCAST_JOIN_SOURCE_GROUP, ifindex, group, source)
+	}
+	return setGroupReq(fd, unix.MCAST_JOIN_GROUP, ifindex, group, nil)
+}
+
+// LeaveGroup leaves the multicast group joined by JoinGroup with the same arguments.
+func LeaveGroup(fd, ifindex int, group, source net.IP) error {
+	if source != nil {
+		return setGroupReq(fd, unix.MCAST_LEAVE_SOURCE_GROUP, ifindex, group, source)
+	}
+	return setGroupReq(fd, unix.MCAST_LEAVE_GROUP, ifindex, group, nil)
+}
+
+// setGroupReq sets the protocol-independent multicast option taking struct group_req, or struct group_source_req
+// if source is not nil, see RFC 3678.
+func setGroupReq(fd, opt, ifindex int, group, source net.IP) error {
+	off := int(unsafe.Sizeof(uintptr(0)))
+	size := off + sizeofSockaddrStorage
+	if source != nil {
+		size += sizeofSockaddrStorage
+	}
+	req := make([]byte, size)
+	*(*uint32)(unsafe.Pointer(&req[0])) = uint32(ifindex)
+	level, ok := putSockaddr(req[off:], group)
+	if !ok {
+		return unix.EINVAL
+	}
+	if source != nil {
+		if l, ok := putSockaddr(req[off+sizeofSockaddrStorage:], source); !ok || l != level {
+			return unix.EINVAL
+		}
+	}
+	return unix.SetsockoptString(fd, level, opt, string(req))
+}
+
+// putSockaddr encodes the address into b, it returns the option level of the address family.
+func putSockaddr(b []byte, ip net.IP) (level int, ok bool) {
+	var rsa unix.RawSockaddrAny
+	var n uint32
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &unix.SockaddrInet4{}
+		copy(sa.Addr[:], ip4)
+		n, level = sockaddrToRaw(sa, &rsa), unix.IPPROTO_IP
+	} else if ip16 := ip.To16(); ip16 != nil {
+		sa := &unix.SockaddrInet6{}
+		copy(sa.Addr[:], ip16)
+		n, level = sockaddrToRaw(sa, &rsa), unix.IPPROTO_IPV6
+	} else {
+		return 0, false
+	}
+	copy(b, (*[unix.SizeofSockaddrAny]byte)(unsafe.Pointer(&rsa))[:n])
+	return level, true
+}
+
+// SetMulticastOptions sets up the interface with the given index the multicast datagrams are sent through by the UDP
+// socket, zero for the one chosen by the kernel, whether they are looped back to the local sockets and their TTL,
+// a zero TTL keeps the default of the kernel. It also restricts the datagrams received to those of the groups joined
+// by the socket, rather than those joined by any socket bound to the same port.
+func SetMulticastOptions(fd, ifindex int, loopback bool, ttl int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	var loop int
+	if loopback {
+		loop = 1
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, loop); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl); err != nil {
+				return err
+			}
+		}
+		if ifindex > 0 {
+			if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifindex); err != nil {
+				return err
+			}
+		}
+		// The kernels before 4.20 do not support it, nor the IPv4 options on an IPv6-only socket.
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_ALL, 0)
+	}
+	// An IPv6 socket takes the IPv4 options for the IPv4 groups, if it is a dual-stack one.
+	if err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, loop); err != nil {
+		return ignoreOnIPv6(sa, err)
+	}
+	if ttl > 0 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, ttl); err != nil {
+			return ignoreOnIPv6(sa, err)
+		}
+	}
+	if ifindex > 0 {
+		mreq := &unix.IPMreqn{Ifindex: int32(ifindex)}
+		if err = unix.SetsockoptIPMreqn(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, mreq); err != nil {
+			return ignoreOnIPv6(sa, err)
+		}
+	}
+	return ignoreOnIPv6(sa, unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_ALL, 0))
+}
+
+// ignoreOnIPv6 ignores the error of an IPv4 option set on an IPv6 socket.
+func ignoreOnIPv6(sa unix.Sockaddr, err error) error {
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		return nil
+	}
+	return err
+}
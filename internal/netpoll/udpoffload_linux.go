@@ -0,0 +1,56 @@
+// +build linux
+
+package netpoll
+
+import (
+	"math"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The UDP socket options of the segmentation and receive offloads, see udp(7).
+const (
+	udpSegment = 103 // UDP_SEGMENT
+	udpGRO     = 104 // UDP_GRO
+)
+
+// GROControlSize is the size of the control buffer receiving the segment size of a coalesced datagram.
+var GROControlSize = unix.CmsgSpace(4)
+
+// SetUDPGRO enables the receive offload on the UDP socket, the datagrams of the same flow and size are coalesced
+// and read at once, along with their size in a control message, see GROSegmentSize.
+func SetUDPGRO(fd int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_UDP, udpGRO, 1)
+}
+
+// GROSegmentSize returns the size of the datagrams coalesced into the one read along with the control messages,
+// zero if it is not a coalesced one.
+func GROSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_UDP && msg.Header.Type == udpGRO && len(msg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return 0
+}
+
+// SendSegmented writes buf through the UDP socket as datagrams of size bytes each, but the last one which may be
+// shorter, in one system call by the segmentation offload. It fails with EINVAL if size does not fit the option.
+func SendSegmented(fd int, buf []byte, size int, to unix.Sockaddr) error {
+	if size <= 0 || size > math.MaxUint16 {
+		return unix.EINVAL
+	}
+	oob := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = uint16(size)
+	_, err := unix.SendmsgN(fd, buf, oob, to, 0)
+	return err
+}
@@ -0,0 +1,251 @@
+// Package timingwheel implements a hashed timing wheel which is driven by an event-loop,
+// so that a large number of timers can be served without a goroutine for each of them.
+package timingwheel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Task is the function to run when a timer expires, an error returned by the task is
+// returned from Wheel.Expire.
+type Task func() error
+
+const (
+	timerPending int32 = iota
+	timerFired
+	timerStopped
+)
+
+// Timer 是在时间轮上调度的定时器.
+type Timer struct {
+	when   time.Time     // expiration time
+	period time.Duration // period of a periodic timer, zero for one-shot
+	state  int32         // accessed atomically
+	tick   int64         // tick of the wheel the timer expires at
+	task   Task
+}
+
+// NewTimer creates a timer which expires after d, or every d if periodic, it runs
+// once it has been scheduled on a Wheel. It panics if periodic and d is not positive, as time.NewTicker does.
+func NewTimer(d time.Duration, periodic bool, task Task) *Timer {
+	return newTimer(time.Now(), d, periodic, task)
+}
+
+func newTimer(now time.Time, d time.Duration, periodic bool, task Task) *Timer {
+	if periodic && d <= 0 {
+		panic("timingwheel: non-positive period for a periodic timer")
+	}
+	if d < 0 {
+		d = 0
+	}
+	t := &Timer{when: now.Add(d), task: task}
+	if periodic {
+		t.period = d
+	}
+	return t
+}
+
+// Stop prevents the timer from firing, it is safe to call from any goroutine and returns
+// false if the timer has already fired or been stopped.
+func (t *Timer) Stop() bool {
+	return atomic.CompareAndSwapInt32(&t.state, timerPending, timerStopped)
+}
+
+// Stopped reports whether the timer has been stopped.
+func (t *Timer) Stopped() bool {
+	return atomic.LoadInt32(&t.state) == timerStopped
+}
+
+// Wheel 哈希时间轮, 它不是并发安全的, 只能在所属的事件循环中使用.
+type Wheel struct {
+	tick   time.Duration
+	start  time.Time
+	slots  [][]*Timer
+	mask   int64
+	cursor int64 // the last tick that has been processed
+	next   int64 // tick of the earliest timer, or earlier, -1 if it is to be looked up
+	count  int
+	now    func() time.Time
+}
+
+// New creates a wheel with size slots of the tick duration, size is rounded up to a power of two.
+func New(tick time.Duration, size int) *Wheel {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	w := &Wheel{tick: tick, slots: make([][]*Timer, n), mask: int64(n - 1), next: -1, now: time.Now}
+	w.start = w.now()
+	return w
+}
+
+// Len returns the number of the timers on the wheel, including the stopped ones which
+// have not been removed or come by yet.
+func (w *Wheel) Len() int {
+	return w.count
+}
+
+// ticks returns the number of ticks elapsed from the start of the wheel until t, rounding up if ceil.
+func (w *Wheel) ticks(t time.Time, ceil bool) int64 {
+	d := t.Sub(w.start)
+	n := int64(d / w.tick)
+	if ceil && d%w.tick > 0 {
+		n++
+	}
+	return n
+}
+
+// Schedule puts the timer onto the wheel.
+func (w *Wheel) Schedule(t *Timer) {
+	if w.count == 0 {
+		// Nothing to process in between, skip the idle ticks.
+		if now := w.ticks(w.now(), false); now > w.cursor {
+			w.cursor = now
+		}
+	}
+	t.tick = w.ticks(t.when, true)
+	if t.tick <= w.cursor {
+		t.tick = w.cursor + 1
+	}
+	idx := t.tick & w.mask
+	w.slots[idx] = append(w.slots[idx], t)
+	if w.count == 0 || (w.next >= 0 && t.tick < w.next) {
+		w.next = t.tick
+	}
+	w.count++
+}
+
+// Remove stops the timer and takes it off the wheel at once, rather than leaving it until the wheel comes by.
+// It reports whether the timer has been stopped by the call, as Stop does.
+func (w *Wheel) Remove(t *Timer) bool {
+	stopped := t.Stop()
+	idx := t.tick & w.mask
+	timers := w.slots[idx]
+	for i, st := range timers {
+		if st == t {
+			last := len(timers) - 1
+			timers[i], timers[last] = timers[last], nil
+			w.slots[idx] = timers[:last]
+			w.count--
+			if t.tick == w.next {
+				w.next = -1
+			}
+			break
+		}
+	}
+	return stopped
+}
+
+// AfterFunc schedules task to run after d.
+func (w *Wheel) AfterFunc(d time.Duration, task Task) *Timer {
+	t := newTimer(w.now(), d, false, task)
+	w.Schedule(t)
+	return t
+}
+
+// Every schedules task to run every d, it panics if d is not positive.
+func (w *Wheel) Every(d time.Duration, task Task) *Timer {
+	t := newTimer(w.now(), d, true, task)
+	w.Schedule(t)
+	return t
+}
+
+// Timeout returns the milliseconds until the earliest timer expires, or -1 if there is no timer on the wheel.
+func (w *Wheel) Timeout() int {
+	if w.count == 0 {
+		return -1
+	}
+	if w.next < 0 {
+		w.next = w.earliest()
+	}
+	d := w.start.Add(time.Duration(w.next) * w.tick).Sub(w.now())
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// earliest looks up the tick of the earliest timer, visiting the slots from the cursor on until one has a timer
+// expiring within the current round of the wheel.
+func (w *Wheel) earliest() int64 {
+	next := int64(-1)
+	for i := int64(1); i <= int64(len(w.slots)); i++ {
+		for _, t := range w.slots[(w.cursor+i)&w.mask] {
+			if next < 0 || t.tick < next {
+				next = t.tick
+			}
+		}
+		if next >= 0 && next <= w.cursor+i {
+			break
+		}
+	}
+	return next
+}
+
+// Expire runs the tasks of the timers expired until now, it stops at the first error returned by a task.
+func (w *Wheel) Expire() (err error) {
+	defer func() {
+		if w.next <= w.cursor {
+			w.next = -1 // passed, the earliest of the rest is looked up again
+		}
+	}()
+	now := w.ticks(w.now(), false)
+	if now-w.cursor > int64(len(w.slots)) {
+		// All the slots are due, visit each of them only once.
+		w.cursor = now
+		for i := range w.slots {
+			if err = w.expireSlot(int64(i), now); err != nil {
+				return
+			}
+		}
+		return
+	}
+	for w.cursor < now {
+		w.cursor++
+		if err = w.expireSlot(w.cursor&w.mask, w.cursor); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// expireSlot runs the timers in the slot which expire at or before the given tick.
+func (w *Wheel) expireSlot(idx, tick int64) (err error) {
+	timers := w.slots[idx]
+	if len(timers) == 0 {
+		return
+	}
+	w.slots[idx] = nil
+	kept := timers[:0]
+	for i, t := range timers {
+		if t.Stopped() {
+			// The stopped timers are dropped as soon as the wheel comes by, whether they are due or not.
+			w.count--
+			timers[i] = nil
+			continue
+		}
+		if err != nil || t.tick > tick {
+			kept = append(kept, t)
+			continue
+		}
+		w.count--
+		timers[i] = nil
+		if t.period > 0 {
+			// Skip the periods missed by a late expiration as time.Ticker does.
+			if t.when = t.when.Add(t.period); t.when.Before(w.now()) {
+				t.when = w.now().Add(t.period)
+			}
+			err = t.task()
+			if !t.Stopped() {
+				w.Schedule(t)
+			}
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&t.state, timerPending, timerFired) {
+			err = t.task()
+		}
+	}
+	w.slots[idx] = append(w.slots[idx], kept...)
+	return
+}
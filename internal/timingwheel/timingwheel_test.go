@@ -0,0 +1,166 @@
+package timingwheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestWheel(size int) (*Wheel, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	w := New(10*time.Millisecond, size)
+	w.now = clock.Now
+	w.start = clock.now
+	return w, clock
+}
+
+func TestWheel(t *testing.T) {
+	w, clock := newTestWheel(8)
+	if w.Timeout() != -1 {
+		t.Fatalf("timeout of an empty wheel: %d", w.Timeout())
+	}
+
+	var fired []string
+	record := func(name string) Task {
+		return func() error {
+			fired = append(fired, name)
+			return nil
+		}
+	}
+	w.AfterFunc(25*time.Millisecond, record("a"))
+	w.AfterFunc(200*time.Millisecond, record("b")) // more than a round of the wheel
+	stopped := w.AfterFunc(30*time.Millisecond, record("c"))
+	every := w.Every(40*time.Millisecond, record("d"))
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("a pending timer should be stopped only once")
+	}
+	if w.Timeout() != 30 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+
+	step := func(d time.Duration) error {
+		clock.now = clock.now.Add(d)
+		return w.Expire()
+	}
+	_ = step(20 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("fired early: %v", fired)
+	}
+	_ = step(10 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "a" {
+		t.Fatalf("fired: %v", fired)
+	}
+	_ = step(10 * time.Millisecond)
+	_ = step(40 * time.Millisecond)
+	if len(fired) != 3 || fired[1] != "d" || fired[2] != "d" {
+		t.Fatalf("fired: %v", fired)
+	}
+	every.Stop()
+	_ = step(120 * time.Millisecond)
+	if len(fired) != 4 || fired[3] != "b" {
+		t.Fatalf("fired: %v", fired)
+	}
+	if w.Len() != 0 || w.Timeout() != -1 {
+		t.Fatalf("wheel should be empty, %d timers left", w.Len())
+	}
+}
+
+func TestWheelIdle(t *testing.T) {
+	w, clock := newTestWheel(8)
+	var n int
+	count := func() error {
+		n++
+		return nil
+	}
+	// A late expiration visits each slot once instead of every missed tick.
+	w.AfterFunc(10*time.Millisecond, count)
+	w.AfterFunc(50*time.Millisecond, count)
+	clock.now = clock.now.Add(time.Hour)
+	if err := w.Expire(); err != nil || n != 2 {
+		t.Fatalf("expired %d timers, error: %v", n, err)
+	}
+
+	// Scheduling on an empty wheel skips the idle ticks.
+	clock.now = clock.now.Add(time.Hour)
+	w.AfterFunc(10*time.Millisecond, count)
+	if w.Timeout() != 10 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+	clock.now = clock.now.Add(10 * time.Millisecond)
+	if err := w.Expire(); err != nil || n != 3 {
+		t.Fatalf("expired %d timers, error: %v", n, err)
+	}
+}
+
+func TestWheelTaskError(t *testing.T) {
+	w, clock := newTestWheel(8)
+	errTask := errors.New("task error")
+	w.AfterFunc(10*time.Millisecond, func() error { return errTask })
+	clock.now = clock.now.Add(10 * time.Millisecond)
+	if err := w.Expire(); err != errTask {
+		t.Fatalf("expire should return the task error, got: %v", err)
+	}
+}
+
+func TestWheelTimeout(t *testing.T) {
+	w, clock := newTestWheel(8)
+	nop := func() error { return nil }
+
+	// The timeout is that of the earliest timer, even in a later round of the wheel.
+	w.AfterFunc(200*time.Millisecond, nop)
+	if w.Timeout() != 200 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+	w.AfterFunc(55*time.Millisecond, nop)
+	if w.Timeout() != 60 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+	clock.now = clock.now.Add(60 * time.Millisecond)
+	if err := w.Expire(); err != nil || w.Len() != 1 {
+		t.Fatalf("expire: %v, %d timers left", err, w.Len())
+	}
+	if w.Timeout() != 140 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+}
+
+func TestWheelRemove(t *testing.T) {
+	w, clock := newTestWheel(8)
+	var fired int
+	count := func() error {
+		fired++
+		return nil
+	}
+	a := w.AfterFunc(20*time.Millisecond, count)
+	b := w.Every(20*time.Millisecond, count)
+	c := w.AfterFunc(100*time.Millisecond, count)
+	if !w.Remove(a) || w.Remove(a) || !w.Remove(b) || w.Len() != 1 {
+		t.Fatalf("the removed timers should be taken off the wheel, %d timers left", w.Len())
+	}
+	if w.Timeout() != 100 {
+		t.Fatalf("timeout: %d", w.Timeout())
+	}
+
+	// The timers stopped rather than removed are dropped as the wheel comes by, before they are due.
+	c.Stop()
+	clock.now = clock.now.Add(20 * time.Millisecond)
+	if err := w.Expire(); err != nil || fired != 0 || w.Len() != 0 || w.Timeout() != -1 {
+		t.Fatalf("expire: %v, fired: %d, %d timers left", err, fired, w.Len())
+	}
+}
+
+func TestWheelEveryNonPositive(t *testing.T) {
+	w, _ := newTestWheel(8)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a periodic timer with a non-positive period should panic")
+		}
+	}()
+	w.Every(0, func() error { return nil })
+}
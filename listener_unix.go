@@ -3,9 +3,11 @@
 package netti
 
 import (
+	"crypto/tls"
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -19,6 +21,24 @@ type listener struct {
 	pconn         net.PacketConn
 	lnaddr        net.Addr
 	addr, network string
+	svr           *server           // server serving the listener
+	key           string            // identity of the listener to match the one inherited from the parent process
+	codec         ICodec            // codec for TCP stream of the accepted connections
+	reusePort     bool              // whether SO_REUSEPORT is enable
+	keepAlive     time.Duration     // TCPKeepAlive (SO_KEEPALIVE) of the accepted connections
+	tlsConfig     *tls.Config       // TLS config of the accepted connections, nil for plaintext ones
+	proxyMode     ProxyProtocolMode // handling of the PROXY protocol header of the accepted connections and datagrams
+	sessionIdle   time.Duration     // idle timeout of the UDP sessions, zero if the datagrams are not kept in sessions
+	sessionLoop   *eventloop        // the only event-loop polling the UDP listener with sessions
+	gro           bool              // whether UDP_GRO is enabled, the coalesced datagrams are split on reading
+	multicastIf   int               // index of the interface the multicast groups are joined on, zero for any
+	detached      chan struct{}     // closed once the listener has been removed from all the event-loops
+	keepFile      bool              // whether the unix socket file is kept on closing, since it is inherited or handed over
+}
+
+// inLoops reports whether the listener is polled by every event-loop rather than the main reactor.
+func (ln *listener) inLoops() bool {
+	return ln.reusePort || ln.pconn != nil
 }
 
 // close .
@@ -34,7 +54,7 @@ func (ln *listener) close() {
 			if ln.pconn != nil {
 				sniffError(ln.pconn.Close())
 			}
-			if ln.network == "unix" {
+			if ln.network == "unix" && !ln.keepFile {
 				sniffError(os.RemoveAll(ln.addr))
 			}
 		})
@@ -0,0 +1,64 @@
+package netti
+
+import (
+	"net"
+	"strings"
+)
+
+// MulticastConfig describes the multicast groups joined by a UDP listener, which joins the group of its address,
+// e.g. udp://239.1.2.3:5000, and the groups listed. The listener only receives the datagrams of the groups it has
+// joined, along with the unicast ones sent to its port.
+type MulticastConfig struct {
+	// Interface is the name of the interface the groups are joined on and the datagrams to the groups are sent
+	// through, the zone of an IPv6 address, e.g. udp://[ff02::1%eth0]:5000, or the one chosen by the kernel
+	// if it is empty.
+	Interface string
+
+	// Groups are the groups joined in addition to the group of the address, which is joined for any source
+	// unless it is listed here.
+	Groups []MulticastGroup
+
+	// Loopback indicates whether the datagrams sent to the groups through the listener are looped back to
+	// the local sockets.
+	Loopback bool
+
+	// TTL is the time-to-live of the datagrams sent to the groups through the listener, it defaults to 1.
+	TTL int
+}
+
+// MulticastGroup is a multicast group joined by a UDP listener.
+type MulticastGroup struct {
+	// Group is the address of the group.
+	Group net.IP
+
+	// Source restricts the datagrams to those sent by the source, i.e. source-specific multicast,
+	// nil for any source.
+	Source net.IP
+}
+
+// joins reports whether the config lists the group.
+func (mc *MulticastConfig) joins(group net.IP) bool {
+	for _, g := range mc.Groups {
+		if g.Group.Equal(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// multicastAddr returns the address to listen on if it is a multicast one, nil otherwise.
+func multicastAddr(addr string) *net.UDPAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+	if ip == nil || !ip.IsMulticast() {
+		return nil
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil
+	}
+	return udpAddr
+}
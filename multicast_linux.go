@@ -0,0 +1,90 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"netti/internal/netpoll"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenMulticast listens on the port of the multicast address, on the wildcard address of its family so that
+// the listener can join other groups as well. SO_REUSEADDR is set, as the feeds are usually consumed by many sockets.
+func listenMulticast(group *net.UDPAddr, reusePort bool) (net.PacketConn, error) {
+	network, wildcard := "udp4", net.IPv4zero
+	if group.IP.To4() == nil {
+		network, wildcard = "udp6", net.IPv6unspecified
+	}
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			if err == nil && reusePort {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	addr := &net.UDPAddr{IP: wildcard, Port: group.Port}
+	return lc.ListenPacket(context.Background(), network, addr.String())
+}
+
+// joinMulticast joins the UDP listener to the group of its address, if any, and the groups of the config, the datagrams
+// sent to the groups go through the interface they are joined on. The groups of the config are only joined by
+// a listener bound to a multicast or a wildcard address, as the datagrams of the groups are not delivered to
+// a socket bound to a unicast one. The memberships of an inherited socket are inherited along with it.
+func (ln *listener) joinMulticast(group *net.UDPAddr, mc *MulticastConfig, inherited bool) error {
+	if group == nil && len(mc.Groups) == 0 {
+		return nil
+	}
+	if addr, ok := ln.lnaddr.(*net.UDPAddr); group == nil && (!ok || !addr.IP.IsUnspecified()) {
+		return ErrMulticastUnicastAddr
+	}
+	name := mc.Interface
+	if name == "" && group != nil {
+		name = group.Zone
+	}
+	if name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+		ln.multicastIf = ifi.Index
+	}
+	if inherited {
+		return nil
+	}
+	if err := netpoll.SetMulticastOptions(ln.fd, ln.multicastIf, mc.Loopback, mc.TTL); err != nil {
+		return err
+	}
+	if group != nil && !mc.joins(group.IP) {
+		if err := netpoll.JoinGroup(ln.fd, ln.multicastIf, group.IP, nil); err != nil {
+			return err
+		}
+	}
+	for _, g := range mc.Groups {
+		if err := netpoll.JoinGroup(ln.fd, ln.multicastIf, g.Group, g.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) JoinGroup(group, source net.IP) error {
+	if c.ln == nil || c.ln.pconn == nil {
+		return ErrNotUDPListener
+	}
+	return netpoll.JoinGroup(c.ln.fd, c.ln.multicastIf, group, source)
+}
+
+func (c *conn) LeaveGroup(group, source net.IP) error {
+	if c.ln == nil || c.ln.pconn == nil {
+		return ErrNotUDPListener
+	}
+	return netpoll.LeaveGroup(c.ln.fd, c.ln.multicastIf, group, source)
+}
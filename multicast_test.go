@@ -0,0 +1,146 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testMulticastServer reports the datagrams and joins or leaves the groups on "join <group>" and "leave <group>".
+type testMulticastServer struct {
+	*EventServer
+	frames chan string
+}
+
+func (es *testMulticastServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	var err error
+	fields := strings.Fields(string(frame))
+	if len(fields) == 0 {
+		es.frames <- string(frame)
+		return
+	}
+	switch fields[0] {
+	case "join":
+		err = c.JoinGroup(net.ParseIP(fields[1]), nil)
+	case "leave":
+		err = c.LeaveGroup(net.ParseIP(fields[1]), nil)
+	}
+	if err != nil {
+		es.frames <- err.Error()
+		return
+	}
+	es.frames <- string(frame)
+	return
+}
+
+func TestMulticast(t *testing.T) {
+	es := &testMulticastServer{EventServer: new(EventServer), frames: make(chan string, 8)}
+	h, err := Start(es, "udp://239.255.10.1:0", WithMulticast(MulticastConfig{
+		Interface: "lo",
+		Groups: []MulticastGroup{
+			{Group: net.IPv4(239, 255, 10, 2)},
+			{Group: net.IPv4(232, 1, 1, 1), Source: net.IPv4(127, 0, 0, 1)},
+		},
+		Loopback: true,
+		TTL:      1,
+	}))
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr := h.Server().Addr.(*net.UDPAddr)
+	if !addr.IP.Equal(net.IPv4(239, 255, 10, 1)) || addr.Port == 0 {
+		t.Fatalf("the listener should be known by its group, got: %v", addr)
+	}
+
+	// The datagrams are sent to the groups through the loopback interface.
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer c.Close()
+	raw, _ := c.SyscallConn()
+	_ = raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptInet4Addr(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_IF, [4]byte{127, 0, 0, 1})
+	})
+	if err != nil {
+		t.Fatalf("failed to set the multicast interface: %v", err)
+	}
+	send := func(group, msg string) {
+		t.Helper()
+		if _, err := c.WriteToUDP([]byte(msg), &net.UDPAddr{IP: net.ParseIP(group), Port: addr.Port}); err != nil {
+			t.Fatalf("failed to send to %s: %v", group, err)
+		}
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-es.frames:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	send("239.255.10.1", "address group")
+	expect("address group")
+	send("239.255.10.2", "extra group")
+	expect("extra group")
+	send("232.1.1.1", "source-specific group")
+	expect("source-specific group")
+
+	// The datagrams of the groups not joined are not received, those are sent before the marker.
+	send("239.255.10.3", "not joined")
+	send("239.255.10.1", "marker")
+	expect("marker")
+
+	send("239.255.10.1", "join 239.255.10.3")
+	expect("join 239.255.10.3")
+	send("239.255.10.3", "joined at runtime")
+	expect("joined at runtime")
+	send("239.255.10.3", "leave 239.255.10.3")
+	expect("leave 239.255.10.3")
+	send("239.255.10.3", "left")
+	send("239.255.10.1", "marker")
+	expect("marker")
+}
+
+func TestMulticastSend(t *testing.T) {
+	es := &testMulticastServer{EventServer: new(EventServer), frames: make(chan string, 8)}
+	h, err := Start(es, "udp://239.255.10.4:0", WithMulticast(MulticastConfig{Interface: "lo", Loopback: true}))
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer h.Stop(context.Background())
+	port := h.Server().Addr.(*net.UDPAddr).Port
+
+	// The datagrams sent to the group through the listener go out of the interface the group is joined on,
+	// rather than the one of the default route, and are looped back to the listener itself.
+	sa := &unix.SockaddrInet4{Addr: [4]byte{239, 255, 10, 4}, Port: port}
+	if err = unix.Sendto(h.svr.listeners[0].fd, []byte("sent to the group"), 0, sa); err != nil {
+		t.Fatalf("failed to send to the group: %v", err)
+	}
+	select {
+	case got := <-es.frames:
+		if got != "sent to the group" {
+			t.Fatalf("unexpected datagram: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the datagram sent to the group should be received on the interface")
+	}
+
+	// The groups are not joined by a listener bound to a unicast address.
+	if _, err = Start(es, "udp://127.0.0.1:0", WithMulticast(MulticastConfig{
+		Groups: []MulticastGroup{{Group: net.IPv4(239, 255, 10, 5)}},
+	})); err != ErrMulticastUnicastAddr {
+		t.Fatalf("joining the groups on a unicast address should fail, got: %v", err)
+	}
+}
@@ -1,14 +1,20 @@
 package netti
 
 import (
+	"context"
 	"log"
 	"net"
 	"netti/internal/netpoll"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 )
 
+// serveContextGrace is the time given to the server to shut down after DrainTimeout
+// once the context of ServeContext is done.
+var serveContextGrace = 5 * time.Second
+
 // Serve starts handling events for the specified addresses.
 //
 // Addresses should use a scheme prefix and be formatted
@@ -21,29 +27,130 @@ import (
 //  udp4  - IPv4
 //  udp6  - IPv6
 //  unix  - Unix Domain Socket
+//  systemd - socket passed by systemd socket activation, `systemd://name` picks the socket by
+//            its FileDescriptorName or, failing that, by its index among the passed sockets
 //
 // The "tcp" network scheme is assumed when one is not specified.
+//
+// Serve blocks until the server has been shut down, use Start to run the server
+// in the background and control it through the returned ServerHandle.
 func Serve(eventHandler EventHandler, addr string, opts ...Option) error {
-	var ln listener
-	defer func() {
-		ln.close()
-		if ln.network == "unix" {
-			sniffError(os.RemoveAll(ln.addr))
-		}
-	}()
+	h, err := Start(eventHandler, addr, opts...)
+	if err != nil {
+		return err
+	}
+	return h.Wait()
+}
+
+// ServeContext is like Serve but additionally stops the server once ctx is done. It waits for the stop
+// for DrainTimeout plus a grace period of 5 seconds at most, then returns context.DeadlineExceeded
+// and leaves the server to finish shutting down in the background.
+func ServeContext(ctx context.Context, eventHandler EventHandler, addr string, opts ...Option) error {
+	h, err := Start(eventHandler, addr, opts...)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		stopCtx, cancel := context.WithTimeout(context.Background(), loadOptions(opts...).DrainTimeout+serveContextGrace)
+		defer cancel()
+		return h.Stop(stopCtx)
+	case <-h.Done():
+		return h.Wait()
+	}
+}
 
+// Start starts handling events for the specified address in the background and
+// returns as soon as the event-loops are running, see Serve for the address format.
+// Extra addresses set up with WithListener are served by the same event-loops.
+func Start(eventHandler EventHandler, addr string, opts ...Option) (*ServerHandle, error) {
 	options := loadOptions(opts...)
+	if options.Prefork > 0 {
+		if preforkWorkerIndex() < 0 {
+			return startPreforkMaster(addr, options)
+		}
+		options.ReusePort = true
+	}
+
+	listeners := make([]*listener, 0, 1+len(options.Listeners))
+	closeListeners := func() {
+		for _, ln := range listeners {
+			ln.close()
+		}
+	}
+	ln, err := listen(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	listeners = append(listeners, ln)
+	for _, lc := range options.Listeners {
+		if ln, err = listen(lc.Addr, lc.options(options)); err != nil {
+			closeListeners()
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
 
+	svr, err := serve(eventHandler, listeners, options)
+	if svr == nil {
+		closeListeners()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newServerHandle(svr), nil
+}
+
+// listen creates a non-blocking listener for addr.
+func listen(addr string, options *Options) (*listener, error) {
+	ln := &listener{
+		codec:       options.Codec,
+		reusePort:   options.ReusePort,
+		keepAlive:   options.TCPKeepAlive,
+		tlsConfig:   options.TLSConfig,
+		proxyMode:   options.ProxyProtocol,
+		sessionIdle: options.UDPSessionIdleTimeout,
+		detached:    make(chan struct{}),
+	}
 	ln.network, ln.addr = parseAddr(addr)
-	if ln.network == "unix" {
+	ln.key = ln.network + "://" + ln.addr
+	f := inheritedListener(ln.key)
+	if f == nil && ln.network == "systemd" {
+		var err error
+		if f, err = activatedListener(ln.addr); err != nil {
+			return nil, err
+		}
+	}
+	if ln.network == "unix" && f == nil {
 		sniffError(os.RemoveAll(ln.addr))
 		if runtime.GOOS == "windows" {
-			return ErrProtocolNotSupported
+			return nil, ErrProtocolNotSupported
 		}
 	}
 	var err error
-	if ln.network == "udp" {
-		if options.ReusePort && runtime.GOOS != "windows" {
+	if f != nil && ln.network == "systemd" {
+		// The socket file of an activated unix socket is managed by systemd.
+		ln.keepFile = true
+		if ln.network, err = socketNetwork(int(f.Fd())); err != nil {
+			sniffError(f.Close())
+			return nil, err
+		}
+	}
+	var group *net.UDPAddr // multicast address to listen on
+	if strings.HasPrefix(ln.network, "udp") {
+		group = multicastAddr(ln.addr)
+	}
+	if f != nil {
+		if strings.HasPrefix(ln.network, "udp") {
+			ln.pconn, err = net.FilePacketConn(f)
+		} else {
+			ln.ln, err = net.FileListener(f)
+		}
+		sniffError(f.Close())
+	} else if strings.HasPrefix(ln.network, "udp") {
+		if group != nil {
+			ln.pconn, err = listenMulticast(group, options.ReusePort)
+		} else if options.ReusePort && runtime.GOOS != "windows" {
 			ln.pconn, err = netpoll.ReusePortListenPacket(ln.network, ln.addr)
 		} else {
 			ln.pconn, err = net.ListenPacket(ln.network, ln.addr)
@@ -56,17 +163,31 @@ func Serve(eventHandler EventHandler, addr string, opts ...Option) error {
 		}
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if ln.pconn != nil {
+	if group != nil {
+		// The listener is known by the group rather than the wildcard address it is bound to.
+		group.Port = ln.pconn.LocalAddr().(*net.UDPAddr).Port
+		ln.lnaddr = group
+	} else if ln.pconn != nil {
 		ln.lnaddr = ln.pconn.LocalAddr()
 	} else {
 		ln.lnaddr = ln.ln.Addr()
 	}
 	if err := ln.setNonBlock(); err != nil {
-		return err
+		return nil, err
+	}
+	if ln.pconn != nil && options.UDPGRO {
+		// The datagrams are read one by one if the kernel does not support it.
+		ln.gro = netpoll.SetUDPGRO(ln.fd) == nil
+	}
+	if ln.pconn != nil {
+		if err := ln.joinMulticast(group, &options.Multicast, f != nil); err != nil {
+			ln.close()
+			return nil, err
+		}
 	}
-	return serve(eventHandler, &ln, options)
+	return ln, nil
 }
 
 func parseAddr(addr string) (network, address string) {
@@ -1,6 +1,8 @@
 package netti
 
 import (
+	"crypto/tls"
+	"os"
 	"time"
 )
 
@@ -15,6 +17,42 @@ func loadOptions(options ...Option) *Options {
 	return opts
 }
 
+// ListenerConfig describes an extra address for the server to listen on.
+type ListenerConfig struct {
+	// Addr is the address to listen on, in the same format as the addr passed to Serve.
+	Addr string
+
+	// Options are applied on top of the server options for this listener only,
+	// ReusePort, TCPKeepAlive, Codec, TLSConfig, ProxyProtocol, UDPSessionIdleTimeout, UDPGRO and Multicast are
+	// the ones taken into account.
+	Options []Option
+}
+
+// options returns the options of the listener derived from the server options.
+func (lc ListenerConfig) options(serverOpts *Options) *Options {
+	opts := *serverOpts
+	for _, option := range lc.Options {
+		option(&opts)
+	}
+	// The prefork workers share the listening addresses.
+	opts.ReusePort = opts.ReusePort || serverOpts.Prefork > 0
+	return &opts
+}
+
+// ConnLimitPolicy is the way of handling the connections beyond the limits of MaxConnections and MaxConnectionsPerIP.
+type ConnLimitPolicy int
+
+const (
+	// RejectConnection accepts and closes the connections beyond the limits immediately,
+	// writing RejectMessage to them beforehand if it is set.
+	RejectConnection ConnLimitPolicy = iota
+
+	// PauseAccepting stops polling the listeners once MaxConnections is reached until a connection is closed,
+	// leaving the incoming connections in the backlog. The connections beyond MaxConnectionsPerIP are still
+	// rejected, as the remote address is not known before accepting.
+	PauseAccepting
+)
+
 // Options are set when the client opens.
 type Options struct {
 	// Multicore indicates whether the server will be effectively created with multi-cores, if so,
@@ -27,6 +65,23 @@ type Options struct {
 	// Note: Setting up NumEventLoop will override Multicore.
 	NumEventLoop int
 
+	// LB represents the load-balancing algorithm used when assigning new connections to event-loops,
+	// it takes effect on the listeners served by the main reactor, i.e. TCP and unix without SO_REUSEPORT.
+	LB LoadBalance
+
+	// LoadBalancer is the customized load-balancer, it takes precedence over LB if set.
+	LoadBalancer LoadBalancer
+
+	// EventLoopGroup is the group of event-loops shared with other servers and clients, which serves the connections
+	// instead of event-loops of its own, Multicore, NumEventLoop, LB and LoadBalancer are ignored if it is set.
+	// The main reactor, if any listener needs it, is still run by the server itself.
+	EventLoopGroup *EventLoopGroup
+
+	// Prefork is the number of the worker processes to start, each of which serves the same addresses with
+	// SO_REUSEPORT and its own event-loops. The master process supervises the workers, restarting the crashed ones,
+	// and forwards SIGINT and SIGTERM to them. Unix sockets and the port 0 can not be shared by the workers.
+	Prefork int
+
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
@@ -36,9 +91,116 @@ type Options struct {
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// AcceptFilters are run in order on every newly accepted connection before it is opened,
+	// the connection is closed as soon as a filter rejects it.
+	AcceptFilters []AcceptFilter
+
+	// MaxConnections is the maximum number of the concurrent connections of the server, zero means no limit.
+	MaxConnections int
+
+	// MaxConnectionsPerIP is the maximum number of the concurrent connections from the same remote IP,
+	// zero means no limit. It does not apply to unix sockets.
+	MaxConnectionsPerIP int
+
+	// ConnLimitPolicy is the way of handling the connections beyond the limits.
+	ConnLimitPolicy ConnLimitPolicy
+
+	// RejectMessage is written to the rejected connections before closing them.
+	RejectMessage []byte
+
+	// ConnectTimeout is the maximum time for a Client to establish a connection, zero means no timeout
+	// other than the deadline of the context passed to Client.DialContext.
+	ConnectTimeout time.Duration
+
+	// ReadRateLimit and WriteRateLimit are the maximum bytes per second read from and written to all the
+	// connections of the server in total, zero means no limit. See Conn.SetRateLimit for the limits per connection.
+	ReadRateLimit  int64
+	WriteRateLimit int64
+
+	// ReaderIdleTimeout, WriterIdleTimeout and AllIdleTimeout fire OnIdle of the connections which have not
+	// read, written, or neither read nor written data for the duration respectively. Zero disables the check.
+	ReaderIdleTimeout time.Duration
+	WriterIdleTimeout time.Duration
+	AllIdleTimeout    time.Duration
+
+	// FirstFrameTimeout closes the connections which have not sent a complete frame decoded by the codec
+	// within the duration after being opened, with ErrFirstFrameTimeout. Zero disables the deadline.
+	FirstFrameTimeout time.Duration
+
+	// DrainTimeout is the grace period given to the outstanding connections to finish when the server is
+	// shutting down, new connections are not accepted meanwhile. Zero means closing connections immediately.
+	DrainTimeout time.Duration
+
+	// HotRestartSignal is the signal on which the server starts a new process of the same binary, handing over
+	// the listeners to it, and then shuts down with draining connections, see ServerHandle.Restart.
+	HotRestartSignal os.Signal
+
+	// SignalHandling indicates whether the server handles signals: SIGTERM and SIGINT shut the server down
+	// with draining connections for DrainTimeout, or 30 seconds if it is not set, SIGHUP invokes OnReload
+	// and SIGUSR1 dumps the state of every event-loop to the logger.
+	SignalHandling bool
+
+	// OnReload is invoked within every event-loop on SIGHUP if SignalHandling is set, e.g. to re-read the configs.
+	OnReload func(loop EventLoop) error
+
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
 
+	// TLSConfig enables TLS on the stream connections, accepted and dialed, the records are decrypted and encrypted
+	// within the event-loops between the socket and the codec, so that the codec and the event handler deal with the
+	// plaintext only. The handshake completes before OnOpened, see Conn.TLSConnectionState for its result.
+	// The certificate is selected by SNI through Certificates or GetCertificate, the protocol is negotiated by ALPN
+	// through NextProtos and the client certificates are requested through ClientAuth, as crypto/tls does.
+	// The dialed connections verify the server against the host of the dialed address unless ServerName is set.
+	TLSConfig *tls.Config
+
+	// TLSHandshakeTimeout closes the TLS connections which have not completed the handshake within the duration,
+	// with ErrHandshakeTimeout, it defaults to 10 seconds.
+	TLSHandshakeTimeout time.Duration
+
+	// ProxyProtocol reads the PROXY protocol header, v1 or v2, sent by a proxy in front of the server at the start of
+	// the accepted connections, before the TLS handshake and the codec, and at the start of the datagrams, v2 only.
+	// The connections take the addresses of the client and the server carried by the header as their RemoteAddr and
+	// LocalAddr, and are opened once the header has been read, see Conn.ProxyHeader for its TLVs.
+	// The accept filters and the limits per IP apply to the address of the proxy, which is the one accepted.
+	// The replies to the datagrams are sent to the proxy as well.
+	ProxyProtocol ProxyProtocolMode
+
+	// ProxyHeaderTimeout bounds the wait for the PROXY protocol header of the accepted connections, it defaults to
+	// 10 seconds. The connections are closed with ErrProxyHeaderTimeout when it expires if the header is required,
+	// and opened without a header if it is optional, so that the clients of the protocols in which the server speaks
+	// first are served, set it shorter for them.
+	ProxyHeaderTimeout time.Duration
+
+	// UDPSessionIdleTimeout keeps the datagrams of a peer in a session when it is set: the first datagram from a peer
+	// opens a Conn firing OnOpened, which reacts on the following datagrams from the peer with its context kept,
+	// until no datagram has come from the peer for the duration, the session is closed then with ErrSessionExpired.
+	// The sessions of a listener are kept by the only event-loop polling it, so that they are looked up without locks.
+	// The new sessions pass the accept filters and count against the connection limits as the connections do,
+	// the datagrams opening the rejected ones are dropped.
+	UDPSessionIdleTimeout time.Duration
+
+	// MaxUDPSessions is the maximum number of the concurrent UDP sessions of the server, it defaults to 65536.
+	// The datagrams of the new peers beyond it are dropped until a session is closed.
+	MaxUDPSessions int
+
+	// UDPBatchSize is the number of datagrams read by one recvmmsg and the replies to them written by one sendmmsg,
+	// the socket is drained in batches on each wake-up, it defaults to 32 and 1 reads and writes one datagram at a time.
+	UDPBatchSize int
+
+	// UDPGRO enables the receive offload (UDP_GRO) on the UDP listeners, the datagrams coalesced by the kernel are
+	// split back into the datagrams sent by the peer before React, it is ignored if the kernel does not support it.
+	// See Conn.SendToSegmented for the segmentation offload of the datagrams sent.
+	UDPGRO bool
+
+	// Multicast sets up the multicast groups joined by the UDP listeners, see MulticastConfig,
+	// the listeners on a multicast address join its group even if it is not set. The groups can only be listed
+	// for the listeners on a multicast or a wildcard address, the others fail with ErrMulticastUnicastAddr.
+	Multicast MulticastConfig
+
+	// Listeners are the extra listeners served by the same event-loops alongside the address passed to Serve.
+	Listeners []ListenerConfig
+
 	// Logger is the customized logger for logging info, if it is not set, default standard logger from log package is used.
 	Logger Logger
 }
@@ -64,6 +226,34 @@ func WithNumEventLoop(numEventLoop int) Option {
 	}
 }
 
+// WithLoadBalancing sets up the load-balancing algorithm in server.
+func WithLoadBalancing(lb LoadBalance) Option {
+	return func(opts *Options) {
+		opts.LB = lb
+	}
+}
+
+// WithLoadBalancer sets up a customized load-balancer in server.
+func WithLoadBalancer(loadBalancer LoadBalancer) Option {
+	return func(opts *Options) {
+		opts.LoadBalancer = loadBalancer
+	}
+}
+
+// WithEventLoopGroup sets up the shared group of event-loops.
+func WithEventLoopGroup(group *EventLoopGroup) Option {
+	return func(opts *Options) {
+		opts.EventLoopGroup = group
+	}
+}
+
+// WithPrefork sets up the number of the prefork worker processes.
+func WithPrefork(n int) Option {
+	return func(opts *Options) {
+		opts.Prefork = n
+	}
+}
+
 // WithReusePort sets up SO_REUSEPORT socket option.
 func WithReusePort(reusePort bool) Option {
 	return func(opts *Options) {
@@ -85,6 +275,90 @@ func WithTicker(ticker bool) Option {
 	}
 }
 
+// WithAcceptFilter appends filters to the accept filter chain, e.g. NewCIDRFilter and NewRateLimitFilter.
+func WithAcceptFilter(filters ...AcceptFilter) Option {
+	return func(opts *Options) {
+		opts.AcceptFilters = append(opts.AcceptFilters, filters...)
+	}
+}
+
+// WithMaxConnections sets up the maximum number of the concurrent connections.
+func WithMaxConnections(n int) Option {
+	return func(opts *Options) {
+		opts.MaxConnections = n
+	}
+}
+
+// WithMaxConnectionsPerIP sets up the maximum number of the concurrent connections from the same remote IP.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(opts *Options) {
+		opts.MaxConnectionsPerIP = n
+	}
+}
+
+// WithConnLimitPolicy sets up the way of handling the connections beyond the limits, rejectMessage
+// is written to the rejected connections and can be nil.
+func WithConnLimitPolicy(policy ConnLimitPolicy, rejectMessage []byte) Option {
+	return func(opts *Options) {
+		opts.ConnLimitPolicy = policy
+		opts.RejectMessage = rejectMessage
+	}
+}
+
+// WithConnectTimeout sets up the timeout of establishing connections of a Client.
+func WithConnectTimeout(connectTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.ConnectTimeout = connectTimeout
+	}
+}
+
+// WithRateLimit sets up the maximum bytes per second read from and written to all the connections in total.
+func WithRateLimit(readBps, writeBps int64) Option {
+	return func(opts *Options) {
+		opts.ReadRateLimit = readBps
+		opts.WriteRateLimit = writeBps
+	}
+}
+
+// WithIdleTimeout sets up the reader-idle, writer-idle and all-idle timeouts of the connections, zero disables one.
+func WithIdleTimeout(reader, writer, all time.Duration) Option {
+	return func(opts *Options) {
+		opts.ReaderIdleTimeout = reader
+		opts.WriterIdleTimeout = writer
+		opts.AllIdleTimeout = all
+	}
+}
+
+// WithFirstFrameTimeout sets up the deadline of receiving the first complete frame of the connections.
+func WithFirstFrameTimeout(firstFrameTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.FirstFrameTimeout = firstFrameTimeout
+	}
+}
+
+// WithDrainTimeout sets up the grace period of draining connections on shutdown.
+func WithDrainTimeout(drainTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.DrainTimeout = drainTimeout
+	}
+}
+
+// WithHotRestart sets up the signal which triggers a hot restart, e.g. syscall.SIGUSR2.
+func WithHotRestart(sig os.Signal) Option {
+	return func(opts *Options) {
+		opts.HotRestartSignal = sig
+	}
+}
+
+// WithSignalHandling enables the built-in signal handling, onReload is invoked within every
+// event-loop on SIGHUP and can be nil.
+func WithSignalHandling(onReload func(loop EventLoop) error) Option {
+	return func(opts *Options) {
+		opts.SignalHandling = true
+		opts.OnReload = onReload
+	}
+}
+
 // WithCodec sets up a codec to handle TCP stream.
 func WithCodec(codec ICodec) Option {
 	return func(opts *Options) {
@@ -92,6 +366,76 @@ func WithCodec(codec ICodec) Option {
 	}
 }
 
+// WithTLSConfig sets up TLS on the stream connections.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(opts *Options) {
+		opts.TLSConfig = config
+	}
+}
+
+// WithTLSHandshakeTimeout sets up the timeout of the TLS handshake.
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TLSHandshakeTimeout = timeout
+	}
+}
+
+// WithProxyProtocol sets up the handling of the PROXY protocol header.
+func WithProxyProtocol(mode ProxyProtocolMode) Option {
+	return func(opts *Options) {
+		opts.ProxyProtocol = mode
+	}
+}
+
+// WithProxyHeaderTimeout sets up the timeout of the PROXY protocol header.
+func WithProxyHeaderTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.ProxyHeaderTimeout = timeout
+	}
+}
+
+// WithUDPSessions keeps the datagrams of a peer in a session which expires after the idle timeout.
+func WithUDPSessions(idleTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.UDPSessionIdleTimeout = idleTimeout
+	}
+}
+
+// WithMaxUDPSessions sets up the maximum number of the concurrent UDP sessions.
+func WithMaxUDPSessions(max int) Option {
+	return func(opts *Options) {
+		opts.MaxUDPSessions = max
+	}
+}
+
+// WithUDPBatchSize sets up the number of datagrams read and written in one system call.
+func WithUDPBatchSize(size int) Option {
+	return func(opts *Options) {
+		opts.UDPBatchSize = size
+	}
+}
+
+// WithUDPGRO sets up the receive offload of the UDP listeners.
+func WithUDPGRO(enable bool) Option {
+	return func(opts *Options) {
+		opts.UDPGRO = enable
+	}
+}
+
+// WithMulticast sets up the multicast groups joined by the UDP listeners.
+func WithMulticast(config MulticastConfig) Option {
+	return func(opts *Options) {
+		opts.Multicast = config
+	}
+}
+
+// WithListener adds an extra address to listen on, opts apply to this listener only.
+func WithListener(addr string, opts ...Option) Option {
+	return func(options *Options) {
+		options.Listeners = append(options.Listeners, ListenerConfig{Addr: addr, Options: opts})
+	}
+}
+
 // WithLogger sets up a customized logger.
 func WithLogger(logger Logger) Option {
 	return func(opts *Options) {
@@ -0,0 +1,350 @@
+package netti
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"netti/internal/timingwheel"
+)
+
+// PoolSelection is the way of selecting a connection of a target from the pool.
+type PoolSelection int
+
+const (
+	// SelectRoundRobin selects the connections of a target in turn.
+	SelectRoundRobin PoolSelection = iota
+
+	// SelectLeastPendingWrites selects the connection with the least data in its outbound buffer.
+	SelectLeastPendingWrites
+)
+
+// PoolConfig is the configuration of a Pool, the zero values fall back to the defaults.
+type PoolConfig struct {
+	// MinConns is the number of the connections kept to each target, the closed ones are redialed.
+	MinConns int
+
+	// MaxConns is the maximum number of the connections to each target, it is MinConns or 1 by default.
+	MaxConns int
+
+	// Selection is the way of selecting a connection of a target.
+	Selection PoolSelection
+
+	// Backoff is the delay of redialing a target after the first failure, it doubles after each consecutive
+	// failure up to MaxBackoff, and a random jitter of up to half of it is taken off. 100ms by default.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum delay of redialing a target, 10s by default.
+	MaxBackoff time.Duration
+
+	// HealthCheckInterval is the interval of invoking HealthCheck on each connection.
+	HealthCheckInterval time.Duration
+
+	// HealthCheck probes a connection within its event-loop, e.g. checking the time of the last pong and sending
+	// a ping, the connection is closed with the returned error, if any, and then redialed.
+	HealthCheck func(c Conn) error
+
+	// BreakerThreshold is the number of the consecutive dial failures of a target after which the circuit breaker
+	// opens, then the target is not dialed until BreakerCooldown has passed, when a single dial is let through
+	// to probe it. Zero disables the circuit breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is the time the circuit breaker stays open, 5s by default.
+	BreakerCooldown time.Duration
+}
+
+// Pool manages the outbound connections to a set of targets, it keeps MinConns connections to each target,
+// redialing the closed ones with exponential backoff and jitter, and grows up to MaxConns connections when the
+// existing ones are busy writing. It is safe to use from any goroutine.
+type Pool struct {
+	cli     *Client
+	config  PoolConfig
+	mu      sync.Mutex
+	targets map[string]*poolTarget
+}
+
+// poolTarget is the state of a target, guarded by the mutex of the pool.
+type poolTarget struct {
+	addr     string
+	conns    []*conn
+	dialing  int
+	next     int
+	failures int       // consecutive dial failures
+	retryAt  time.Time // when the open circuit breaker lets a dial through
+	removed  bool
+}
+
+// poolHandler redials the targets of the pool when their connections are closed.
+type poolHandler struct {
+	EventHandler
+	pool *Pool
+}
+
+// OnClosed .
+func (ph *poolHandler) OnClosed(c Conn, err error) (action Action) {
+	ph.pool.detach(c.(*conn))
+	return ph.EventHandler.OnClosed(c, err)
+}
+
+// NewPool starts a pool whose connections are served by a Client with eventHandler and opts.
+func NewPool(eventHandler EventHandler, config PoolConfig, opts ...Option) (*Pool, error) {
+	if config.MaxConns <= 0 {
+		config.MaxConns = config.MinConns
+		if config.MaxConns <= 0 {
+			config.MaxConns = 1
+		}
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 10 * time.Second
+	}
+	if config.BreakerCooldown <= 0 {
+		config.BreakerCooldown = 5 * time.Second
+	}
+	p := &Pool{config: config, targets: make(map[string]*poolTarget)}
+	cli, err := NewClient(&poolHandler{EventHandler: eventHandler, pool: p}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.cli = cli
+	return p, nil
+}
+
+// Add adds a target to the pool and dials its MinConns connections in the background.
+func (p *Pool) Add(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.targets[addr]; ok {
+		return
+	}
+	t := &poolTarget{addr: addr}
+	p.targets[addr] = t
+	p.fill(t, 0)
+}
+
+// Remove removes a target from the pool and closes its connections.
+func (p *Pool) Remove(addr string) error {
+	p.mu.Lock()
+	t, ok := p.targets[addr]
+	if !ok {
+		p.mu.Unlock()
+		return ErrTargetNotFound
+	}
+	delete(p.targets, addr)
+	t.removed = true
+	conns := t.conns
+	p.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// Get selects an open connection to the target, dialing one if there is none.
+// It fails with ErrCircuitOpen if the circuit breaker of the target is open.
+func (p *Pool) Get(addr string) (Conn, error) {
+	p.mu.Lock()
+	t, ok := p.targets[addr]
+	if !ok {
+		p.mu.Unlock()
+		return nil, ErrTargetNotFound
+	}
+	if c := p.selectConn(t); c != nil {
+		// Grow the pool if even the selected connection is busy.
+		if c.pendingWrites() > 0 && len(t.conns)+t.dialing < p.config.MaxConns && p.allow(t) {
+			t.dialing++
+			go func() { _, _ = p.dial(t) }()
+		}
+		p.mu.Unlock()
+		return c, nil
+	}
+	if p.open(t) {
+		p.mu.Unlock()
+		return nil, ErrCircuitOpen
+	}
+	if len(t.conns)+t.dialing >= p.config.MaxConns {
+		p.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	if !p.allow(t) {
+		p.mu.Unlock()
+		return nil, ErrCircuitOpen
+	}
+	t.dialing++
+	p.mu.Unlock()
+	c, err := p.dial(t)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stop closes all the connections and stops the client of the pool, see ServerHandle.Stop.
+func (p *Pool) Stop(ctx context.Context) error {
+	return p.cli.Stop(ctx)
+}
+
+// selectConn selects a connection of the target, it must be called with the mutex held.
+func (p *Pool) selectConn(t *poolTarget) *conn {
+	if len(t.conns) == 0 {
+		return nil
+	}
+	if p.config.Selection == SelectLeastPendingWrites {
+		best := t.conns[0]
+		for _, c := range t.conns[1:] {
+			if c.pendingWrites() < best.pendingWrites() {
+				best = c
+			}
+		}
+		return best
+	}
+	t.next = (t.next + 1) % len(t.conns)
+	return t.conns[t.next]
+}
+
+// open reports whether the circuit breaker of the target is open, it must be called with the mutex held.
+func (p *Pool) open(t *poolTarget) bool {
+	return p.config.BreakerThreshold > 0 && t.failures >= p.config.BreakerThreshold && time.Now().Before(t.retryAt)
+}
+
+// allow reports whether the circuit breaker of the target lets a dial through, it must be called with the mutex held.
+func (p *Pool) allow(t *poolTarget) bool {
+	if p.config.BreakerThreshold <= 0 || t.failures < p.config.BreakerThreshold {
+		return true
+	}
+	if p.open(t) {
+		return false
+	}
+	// Half-open, let a single dial through per cooldown.
+	t.retryAt = time.Now().Add(p.config.BreakerCooldown)
+	return true
+}
+
+// dial dials a connection to the target which has been counted in its dialing.
+func (p *Pool) dial(t *poolTarget) (*conn, error) {
+	c, err := p.cli.h.svr.dial(context.Background(), t.addr, func(c *conn) { p.attach(t, c) })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.dialing--
+	if err != nil {
+		if t.failures++; p.config.BreakerThreshold > 0 && t.failures == p.config.BreakerThreshold {
+			t.retryAt = time.Now().Add(p.config.BreakerCooldown)
+		}
+		p.fill(t, p.backoff(t.failures))
+		return nil, err
+	}
+	t.failures = 0
+	return c, nil
+}
+
+// attach adds a newly connected connection to the target, it is invoked within the loop of the connection.
+func (p *Pool) attach(t *poolTarget, c *conn) {
+	p.mu.Lock()
+	removed := t.removed
+	if !removed {
+		t.conns = append(t.conns, c)
+	}
+	p.mu.Unlock()
+	if removed {
+		_ = c.Close()
+		return
+	}
+	if p.config.HealthCheck != nil && p.config.HealthCheckInterval > 0 {
+		var probe *timingwheel.Timer
+		probe = timingwheel.NewTimer(p.config.HealthCheckInterval, true, func() error {
+			if err := p.config.HealthCheck(c); err != nil && c.opened {
+				probe.Stop()
+				return c.loop.loopCloseConn(c, err)
+			}
+			return nil
+		})
+		c.addTimer(probe)
+	}
+}
+
+// detach removes a closed connection from its target and redials the target if needed, the targets are not
+// redialed once the client is shut down.
+func (p *Pool) detach(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.targets {
+		for i, cc := range t.conns {
+			if cc == c {
+				t.conns = append(t.conns[:i], t.conns[i+1:]...)
+				// Back off as after a failure, in case the target keeps closing the connections.
+				p.fill(t, p.backoff(t.failures+1))
+				return
+			}
+		}
+	}
+}
+
+// fill dials the target in the background after delay until it has MinConns connections, it does nothing once
+// the client is shut down. It must be called with the mutex held.
+func (p *Pool) fill(t *poolTarget, delay time.Duration) {
+	if p.cli.h.svr.isShutdown() {
+		return
+	}
+	for !t.removed && len(t.conns)+t.dialing < p.config.MinConns {
+		t.dialing++
+		go p.redial(t, delay)
+	}
+}
+
+// redial dials the target after delay, waiting for the circuit breaker if it is open.
+func (p *Pool) redial(t *poolTarget, delay time.Duration) {
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-p.cli.Done():
+			timer.Stop()
+			p.mu.Lock()
+			t.dialing--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Lock()
+		if t.removed {
+			t.dialing--
+			p.mu.Unlock()
+			return
+		}
+		if !p.allow(t) {
+			delay = time.Until(t.retryAt)
+			p.mu.Unlock()
+			continue
+		}
+		p.mu.Unlock()
+		_, _ = p.dial(t)
+		return
+	}
+}
+
+// backoff returns the delay of redialing after the given number of consecutive failures.
+func (p *Pool) backoff(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	d := p.config.Backoff
+	for i := 1; i < failures && d < p.config.MaxBackoff; i++ {
+		d <<= 1
+	}
+	if d > p.config.MaxBackoff {
+		d = p.config.MaxBackoff
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Len returns the number of the open connections to the target.
+func (p *Pool) Len(addr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.targets[addr]; ok {
+		return len(t.conns)
+	}
+	return 0
+}
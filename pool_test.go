@@ -0,0 +1,169 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type testPoolHandler struct {
+	*EventServer
+	closed chan error
+}
+
+func (ph *testPoolHandler) OnClosed(c Conn, err error) (action Action) {
+	select {
+	case ph.closed <- err:
+	default:
+	}
+	return
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	h, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr := "tcp://" + h.Server().Addr.String()
+
+	errProbe := errors.New("probe failed")
+	probing := make(chan struct{})
+	ph := &testPoolHandler{EventServer: new(EventServer), closed: make(chan error, 16)}
+	p, err := NewPool(ph, PoolConfig{
+		MinConns:            2,
+		MaxConns:            3,
+		Backoff:             10 * time.Millisecond,
+		HealthCheckInterval: 20 * time.Millisecond,
+		HealthCheck: func(c Conn) error {
+			select {
+			case <-probing:
+				return errProbe
+			default:
+				return nil
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start pool: %v", err)
+	}
+	defer p.Stop(context.Background())
+	if _, err = p.Get(addr); err != ErrTargetNotFound {
+		t.Fatalf("getting an unknown target should fail, got: %v", err)
+	}
+	p.Add(addr)
+	waitFor(t, "the minimum connections", func() bool { return p.Len(addr) == 2 })
+
+	c1, err := p.Get(addr)
+	if err != nil {
+		t.Fatalf("failed to get a connection: %v", err)
+	}
+	c2, _ := p.Get(addr)
+	if c1 == c2 {
+		t.Fatal("the connections should be selected in turn")
+	}
+
+	// The closed connections are redialed.
+	_ = c1.Close()
+	<-ph.closed
+	waitFor(t, "the redialed connection", func() bool { return p.Len(addr) == 2 })
+
+	// The connections failing the health check are closed and redialed.
+	close(probing)
+	if err = <-ph.closed; err != errProbe {
+		t.Fatalf("unexpected error closing an unhealthy connection: %v", err)
+	}
+
+	if err = p.Remove(addr); err != nil {
+		t.Fatalf("failed to remove the target: %v", err)
+	}
+	if _, err = p.Get(addr); err != ErrTargetNotFound {
+		t.Fatalf("getting a removed target should fail, got: %v", err)
+	}
+}
+
+func TestPoolCircuitBreaker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "tcp://" + ln.Addr().String()
+	_ = ln.Close()
+
+	p, err := NewPool(new(EventServer), PoolConfig{
+		MinConns:         1,
+		Backoff:          5 * time.Millisecond,
+		BreakerThreshold: 3,
+		BreakerCooldown:  time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to start pool: %v", err)
+	}
+	defer p.Stop(context.Background())
+	p.Add(addr)
+	waitFor(t, "the circuit breaker", func() bool {
+		_, err = p.Get(addr)
+		return err == ErrCircuitOpen
+	})
+}
+
+func TestPoolStop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "tcp://" + ln.Addr().String()
+	_ = ln.Close()
+
+	p, err := NewPool(new(EventServer), PoolConfig{MinConns: 1, Backoff: time.Hour, MaxBackoff: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to start pool: %v", err)
+	}
+	p.Add(addr)
+	dialing := func() int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.targets[addr].dialing
+	}
+	waitFor(t, "the redial after a failure", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.targets[addr].failures == 1
+	})
+	if n := dialing(); n != 1 {
+		t.Fatalf("a redial should be pending, dialing: %d", n)
+	}
+
+	// The pending redial is given up and no target is dialed once the client is shut down.
+	if err = p.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop pool: %v", err)
+	}
+	waitFor(t, "the pending redial to be given up", func() bool { return dialing() == 0 })
+	p.Add(addr + "0")
+	p.mu.Lock()
+	n := p.targets[addr+"0"].dialing
+	p.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("a target added after the shutdown should not be dialed, dialing: %d", n)
+	}
+}
+
+func TestPoolSelection(t *testing.T) {
+	p := &Pool{config: PoolConfig{Selection: SelectLeastPendingWrites}}
+	busy, idle := &conn{pending: 10}, &conn{}
+	if c := p.selectConn(&poolTarget{conns: []*conn{busy, idle}}); c != idle {
+		t.Fatal("the connection with the least pending writes should be selected")
+	}
+}
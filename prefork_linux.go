@@ -0,0 +1,283 @@
+// +build linux
+
+package netti
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// envPreforkWorker is the environment variable which holds the index of a prefork worker process.
+const envPreforkWorker = "NETTI_PREFORK_WORKER"
+
+const (
+	preforkRestartDelay    = time.Second      // delay before restarting a crashed worker process the first time
+	preforkMaxRestartDelay = 30 * time.Second // the delay doubles on every crash in a row up to it
+	preforkMaxRestarts     = 10               // crashes in a row after which a worker process is not restarted
+)
+
+var (
+	preforkOnce  sync.Once
+	preforkIndex = -1
+)
+
+// loadPreforkWorker takes the index of the current process as a prefork worker, the environment variable is unset
+// to prevent the child processes from taking themselves for workers.
+func loadPreforkWorker() {
+	v, ok := os.LookupEnv(envPreforkWorker)
+	if !ok {
+		return
+	}
+	sniffError(os.Unsetenv(envPreforkWorker))
+	if idx, err := strconv.Atoi(v); err == nil {
+		preforkIndex = idx
+	}
+}
+
+// preforkWorkerIndex returns the index of the current process among the prefork worker processes,
+// or -1 if the current process is not a worker.
+func preforkWorkerIndex() int {
+	preforkOnce.Do(loadPreforkWorker)
+	return preforkIndex
+}
+
+// preforkRestartBackoff returns the delay before restarting a worker process which has crashed n times in a row.
+func preforkRestartBackoff(n int) time.Duration {
+	d := preforkRestartDelay
+	for i := 1; i < n && d < preforkMaxRestartDelay; i++ {
+		d *= 2
+	}
+	if d > preforkMaxRestartDelay {
+		d = preforkMaxRestartDelay
+	}
+	return d
+}
+
+// prefork supervises the worker processes in prefork mode.
+type prefork struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	procs    []*os.Process
+	logger   Logger
+	stopping bool
+	stopSig  os.Signal     // signal the workers are stopped with
+	stopped  chan struct{} // closed once stopping is set
+	done     chan struct{}
+}
+
+// startPrefork starts n worker processes of the same binary with the same arguments, every
+// worker serves the same addresses with SO_REUSEPORT. SIGHUP and SIGUSR1 are forwarded to
+// the workers as well if signal handling is enabled. The master process becomes the subreaper
+// of the workers, so that the new process of a worker restarted by ServerHandle.Restart is
+// adopted by the master and supervised in place of the worker.
+func startPrefork(n int, logger Logger, signalHandling bool) (*prefork, error) {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return nil, os.NewSyscallError("prctl", err)
+	}
+	p := &prefork{procs: make([]*os.Process, n), logger: logger, stopped: make(chan struct{}), done: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		proc, err := p.spawn(i)
+		if err != nil {
+			p.stop(syscall.SIGTERM)
+			return nil, err
+		}
+		p.procs[i] = proc
+		p.wg.Add(1)
+		go p.supervise(i, proc)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	if signalHandling {
+		signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGUSR1)
+	}
+	go func() {
+		waitCh := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(waitCh)
+		}()
+		for {
+			select {
+			case sig := <-sigCh:
+				if sig == syscall.SIGHUP || sig == syscall.SIGUSR1 {
+					p.signal(sig)
+				} else {
+					p.stop(sig)
+				}
+			case <-waitCh:
+				signal.Stop(sigCh)
+				close(p.done)
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// spawn starts the worker process with the given index.
+func (p *prefork) spawn(idx int) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), envPreforkWorker+"="+strconv.Itoa(idx))
+	// Make sure the worker does not outlive the master process.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// supervise waits for the worker process to exit and restarts it unless it exits successfully
+// or the workers are being stopped. The restarts back off exponentially while the worker keeps crashing
+// shortly after starting, and the worker is given up after preforkMaxRestarts crashes in a row.
+// A worker exiting successfully after a hot restart is succeeded by its new process, which is supervised then.
+func (p *prefork) supervise(idx int, proc *os.Process) {
+	defer p.wg.Done()
+	var crashes int
+	started := time.Now()
+	for {
+		state, err := proc.Wait()
+		if err == nil && state.Success() {
+			if proc = p.adopt(idx); proc == nil {
+				return
+			}
+			p.logger.Printf("prefork worker:%d is restarted as pid:%d\n", idx, proc.Pid)
+			started = time.Now()
+			continue
+		}
+		p.mu.Lock()
+		stopping := p.stopping
+		p.mu.Unlock()
+		if stopping {
+			return
+		}
+		if err == nil {
+			err = &exec.ExitError{ProcessState: state}
+		}
+		if time.Since(started) >= preforkMaxRestartDelay {
+			crashes = 0
+		}
+		if crashes++; crashes > preforkMaxRestarts {
+			p.logger.Printf("prefork worker:%d exits with error:%v, giving up after %d restarts\n",
+				idx, err, preforkMaxRestarts)
+			return
+		}
+		delay := preforkRestartBackoff(crashes)
+		p.logger.Printf("prefork worker:%d exits with error:%v, restarting in %v\n", idx, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-p.stopped:
+			return
+		}
+		p.mu.Lock()
+		if p.stopping {
+			p.mu.Unlock()
+			return
+		}
+		if proc, err = p.spawn(idx); err != nil {
+			p.mu.Unlock()
+			p.logger.Printf("failed to restart prefork worker:%d with error:%v\n", idx, err)
+			return
+		}
+		p.procs[idx] = proc
+		started = time.Now()
+		p.mu.Unlock()
+	}
+}
+
+// adopt takes the new process started by the hot restart of the worker with the given index in place of the worker,
+// it returns nil if there is none. The new process has been adopted by the master as an orphan once the worker
+// has exited, it is told apart from the other children by the index passed on in its environment.
+// The new process is stopped right away if the workers are being stopped.
+func (p *prefork) adopt(idx int) *os.Process {
+	pid := findPreforkWorker(idx)
+	if pid <= 0 {
+		return nil
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.procs[idx] = proc
+	if p.stopping {
+		_ = proc.Signal(p.stopSig)
+	}
+	return proc
+}
+
+// findPreforkWorker returns the pid of the child process of the master running as the worker with the given index,
+// or 0 if there is none.
+func findPreforkWorker(idx int) int {
+	dir, err := os.Open("/proc")
+	if err != nil {
+		return 0
+	}
+	names, _ := dir.Readdirnames(-1)
+	_ = dir.Close()
+	ppid := []byte(" " + strconv.Itoa(os.Getpid()) + " ")
+	env := []byte(envPreforkWorker + "=" + strconv.Itoa(idx))
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		// The parent pid follows the state after the command name, which may contain anything but ends with ')'.
+		stat, err := ioutil.ReadFile("/proc/" + name + "/stat")
+		if i := bytes.LastIndexByte(stat, ')'); err != nil || i < 0 || len(stat) < i+3 ||
+			!bytes.HasPrefix(stat[i+3:], ppid) {
+			continue
+		}
+		environ, _ := ioutil.ReadFile("/proc/" + name + "/environ")
+		for _, kv := range bytes.Split(environ, []byte{0}) {
+			if bytes.Equal(kv, env) {
+				return pid
+			}
+		}
+	}
+	return 0
+}
+
+// terminate stops the worker processes gracefully.
+func (p *prefork) terminate() {
+	p.stop(syscall.SIGTERM)
+}
+
+// stop stops restarting the worker processes and forwards sig to them.
+func (p *prefork) stop(sig os.Signal) {
+	p.mu.Lock()
+	if !p.stopping {
+		p.stopping = true
+		p.stopSig = sig
+		close(p.stopped)
+	}
+	p.mu.Unlock()
+	p.signal(sig)
+}
+
+// signal forwards sig to the worker processes.
+func (p *prefork) signal(sig os.Signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proc := range p.procs {
+		if proc != nil {
+			_ = proc.Signal(sig)
+		}
+	}
+}
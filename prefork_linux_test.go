@@ -0,0 +1,130 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// envTestPreforkAddr holds the address served by the workers of TestPrefork.
+const envTestPreforkAddr = "NETTI_TEST_PREFORK_ADDR"
+
+type testPreforkWorker struct {
+	*EventServer
+	idx int
+}
+
+func (es *testPreforkWorker) OnInitComplete(svr Server) (action Action) {
+	if !svr.Prefork {
+		action = Shutdown
+	}
+	es.idx = svr.WorkerIndex
+	return
+}
+
+func (es *testPreforkWorker) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = []byte("worker:" + strconv.Itoa(es.idx))
+	return
+}
+
+func TestPrefork(t *testing.T) {
+	if addr := os.Getenv(envTestPreforkAddr); preforkWorkerIndex() >= 0 {
+		if _, ok := os.LookupEnv(envPreforkWorker); ok {
+			t.Fatal("the worker index should not be passed on to the child processes of a worker")
+		}
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM)
+		restarted := os.Getenv(envInheritedListeners) != ""
+		h, err := Start(&testPreforkWorker{EventServer: new(EventServer)}, addr, WithPrefork(2))
+		if err != nil {
+			t.Fatalf("worker failed to start: %v", err)
+		}
+		// The first worker hands over to a new process once, which the master supervises in its place.
+		if preforkWorkerIndex() == 0 && !restarted {
+			if err = h.Restart(); err != nil {
+				t.Fatalf("worker failed to restart: %v", err)
+			}
+		}
+		select {
+		case <-sigCh:
+		case <-h.Done():
+		case <-time.After(10 * time.Second):
+		}
+		if err = h.Stop(context.Background()); err != nil {
+			t.Fatalf("worker failed to stop: %v", err)
+		}
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestPrefork$"}
+	defer func() { os.Args = args }()
+	_ = os.Setenv(envTestPreforkAddr, "tcp://"+addr)
+	defer os.Unsetenv(envTestPreforkAddr)
+	h, err := Start(&testPreforkWorker{EventServer: new(EventServer)}, "tcp://"+addr, WithPrefork(2))
+	if err != nil {
+		t.Fatalf("failed to start prefork master: %v", err)
+	}
+	h.prefork.mu.Lock()
+	pid := h.prefork.procs[0].Pid
+	h.prefork.mu.Unlock()
+
+	workers := make(map[string]bool)
+	for deadline := time.Now().Add(5 * time.Second); len(workers) < 2 && time.Now().Before(deadline); {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		_ = c.SetDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, len("worker:0"))
+		if _, err = c.Write([]byte("ping")); err == nil {
+			if _, err = c.Read(buf); err == nil {
+				workers[string(buf)] = true
+			}
+		}
+		_ = c.Close()
+	}
+	if !workers["worker:0"] || !workers["worker:1"] {
+		t.Fatalf("connections are not served by both workers: %v", workers)
+	}
+	waitFor(t, "the restarted worker to be adopted", func() bool {
+		h.prefork.mu.Lock()
+		defer h.prefork.mu.Unlock()
+		return h.prefork.procs[0].Pid != pid
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = h.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop prefork master: %v", err)
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		_ = c.Close()
+		t.Fatal("no worker should be left serving after the master has stopped")
+	}
+}
+
+func TestPreforkRestartBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		preforkMaxRestartDelay, preforkMaxRestartDelay}
+	for i, d := range want {
+		if got := preforkRestartBackoff(i + 1); got != d {
+			t.Fatalf("restart delay after %d crashes: got %v, want %v", i+1, got, d)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package netti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ProxyProtocolMode is the way of handling the PROXY protocol header sent by a proxy in front of the server,
+// e.g. HAProxy or an L4 load-balancer, at the start of the connections to pass on the addresses of the clients.
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolOff does not look for the header, the data is passed on as it is.
+	ProxyProtocolOff ProxyProtocolMode = iota
+
+	// ProxyProtocolOptional reads the header if the data starts with one, and passes on the data as it is otherwise.
+	ProxyProtocolOptional
+
+	// ProxyProtocolRequired reads the header and closes the connections without a valid one with ErrProxyHeader,
+	// the datagrams without a valid one are dropped.
+	ProxyProtocolRequired
+)
+
+// The types of the TLVs of the PROXY protocol v2 header.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+// ProxyHeader is the PROXY protocol header received at the start of a connection or a datagram.
+type ProxyHeader struct {
+	// Version is 1 for the text header and 2 for the binary one.
+	Version int
+
+	// Local indicates a connection made by the proxy itself, e.g. a health check, rather than on behalf of a client,
+	// or one whose addresses are not passed on, i.e. the LOCAL command or the UNSPEC family or transport of v2
+	// or the UNKNOWN protocol of v1. Source and Destination are nil then and the connection keeps its own addresses.
+	Local bool
+
+	// Source and Destination are the addresses of the client and the server as seen by the proxy,
+	// which the connection takes as its RemoteAddr and LocalAddr.
+	Source      net.Addr
+	Destination net.Addr
+
+	// TLVs are the additional information passed on by the v2 header, in the order they were sent.
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a type-length-value vector of the PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first TLV of the given type.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the host name the client connected to, e.g. the SNI of TLS, passed on by the proxy.
+func (h *ProxyHeader) Authority() string {
+	v, _ := h.TLV(ProxyTLVAuthority)
+	return string(v)
+}
+
+// UniqueID returns the unique ID of the connection assigned by the proxy.
+func (h *ProxyHeader) UniqueID() []byte {
+	v, _ := h.TLV(ProxyTLVUniqueID)
+	return v
+}
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyV1MaxLength    = 107 // including the CRLF
+	proxyV2HeaderLength = 16  // signature, version and command, family and transport, length
+)
+
+// errNotProxy is returned when the data does not start with a PROXY protocol header.
+var errNotProxy = errors.New("no PROXY protocol header")
+
+// parseProxyHeader parses the PROXY protocol header at the start of buf, v1 is only looked for if allowV1 is true.
+// It returns the header and its length, or a zero length and a nil error if buf is too short to tell yet,
+// errNotProxy if buf does not start with a header and ErrProxyHeader if the header is malformed.
+func parseProxyHeader(buf []byte, allowV1 bool) (*ProxyHeader, int, error) {
+	if hasPrefix(buf, proxyV2Signature) {
+		if len(buf) < len(proxyV2Signature) {
+			return nil, 0, nil
+		}
+		return parseProxyV2(buf)
+	}
+	if allowV1 && hasPrefix(buf, proxyV1Signature) {
+		if len(buf) < len(proxyV1Signature) {
+			return nil, 0, nil
+		}
+		return parseProxyV1(buf)
+	}
+	return nil, 0, errNotProxy
+}
+
+// hasPrefix reports whether buf and prefix agree as far as both of them go.
+func hasPrefix(buf, prefix []byte) bool {
+	if len(buf) < len(prefix) {
+		return bytes.HasPrefix(prefix, buf)
+	}
+	return bytes.HasPrefix(buf, prefix)
+}
+
+// parseProxyV1 parses a text header, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func parseProxyV1(buf []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 {
+		if len(buf) >= proxyV1MaxLength {
+			return nil, 0, ErrProxyHeader
+		}
+		return nil, 0, nil
+	}
+	if end+2 > proxyV1MaxLength {
+		return nil, 0, ErrProxyHeader
+	}
+	h := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(buf[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, err1 := parseProxyPort(fields[4])
+	dport, err2 := parseProxyPort(fields[5])
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, 0, ErrProxyHeader
+	}
+	if v4 := fields[1] == "TCP4"; v4 != (src.To4() != nil) || v4 != (dst.To4() != nil) {
+		return nil, 0, ErrProxyHeader
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: sport}
+	h.Destination = &net.TCPAddr{IP: dst, Port: dport}
+	return h, end + 2, nil
+}
+
+// parseProxyPort parses a port of the text header, which is a decimal number without leading zeros.
+func parseProxyPort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 0xffff || (len(s) > 1 && s[0] == '0') {
+		return 0, ErrProxyHeader
+	}
+	return port, nil
+}
+
+// parseProxyV2 parses a binary header.
+func parseProxyV2(buf []byte) (*ProxyHeader, int, error) {
+	if len(buf) < proxyV2HeaderLength {
+		return nil, 0, nil
+	}
+	verCmd, famProto := buf[12], buf[13]
+	if verCmd>>4 != 2 || verCmd&0xf > 1 {
+		return nil, 0, ErrProxyHeader
+	}
+	n := proxyV2HeaderLength + int(binary.BigEndian.Uint16(buf[14:16]))
+	if len(buf) < n {
+		return nil, 0, nil
+	}
+	// The addresses of the UNSPEC family or transport are ignored as those of the LOCAL command.
+	h := &ProxyHeader{Version: 2, Local: verCmd&0xf == 0 || famProto>>4 == 0 || famProto&0xf == 0}
+	payload := buf[proxyV2HeaderLength:n]
+
+	var addrLen int
+	switch famProto >> 4 {
+	case 0:
+	case 1:
+		addrLen = 12
+	case 2:
+		addrLen = 36
+	case 3:
+		addrLen = 216
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	if len(payload) < addrLen {
+		return nil, 0, ErrProxyHeader
+	}
+	if !h.Local {
+		var ok bool
+		if h.Source, h.Destination, ok = proxyV2Addrs(famProto, payload[:addrLen]); !ok {
+			return nil, 0, ErrProxyHeader
+		}
+	}
+
+	for tlvs := payload[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, 0, ErrProxyHeader
+		}
+		l := 3 + int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < l {
+			return nil, 0, ErrProxyHeader
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: append([]byte(nil), tlvs[3:l]...)})
+		tlvs = tlvs[l:]
+	}
+	return h, n, nil
+}
+
+// proxyV2Addrs returns the addresses of the given family and transport protocol of a binary header.
+func proxyV2Addrs(famProto byte, b []byte) (src, dst net.Addr, ok bool) {
+	stream := famProto&0xf == 1
+	if famProto&0xf != 1 && famProto&0xf != 2 {
+		return nil, nil, false
+	}
+	inet := func(ip net.IP, port []byte) net.Addr {
+		ip = append(net.IP(nil), ip...)
+		p := int(binary.BigEndian.Uint16(port))
+		if stream {
+			return &net.TCPAddr{IP: ip, Port: p}
+		}
+		return &net.UDPAddr{IP: ip, Port: p}
+	}
+	switch famProto >> 4 {
+	case 1:
+		return inet(b[0:4], b[8:10]), inet(b[4:8], b[10:12]), true
+	case 2:
+		return inet(b[0:16], b[32:34]), inet(b[16:32], b[34:36]), true
+	case 3:
+		network := "unix"
+		if !stream {
+			network = "unixgram"
+		}
+		path := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		return &net.UnixAddr{Name: path(b[:108]), Net: network}, &net.UnixAddr{Name: path(b[108:216]), Net: network}, true
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,264 @@
+// +build linux
+
+package netti
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// appendUint16 appends v in big-endian.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// proxyV2 builds a PROXY protocol v2 header of the PROXY command with the given IPv4 addresses and TLVs.
+func proxyV2(transport byte, src, dst *net.TCPAddr, tlvs ...ProxyTLV) []byte {
+	payload := append(append([]byte(nil), src.IP.To4()...), dst.IP.To4()...)
+	payload = appendUint16(payload, uint16(src.Port))
+	payload = appendUint16(payload, uint16(dst.Port))
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type)
+		payload = appendUint16(payload, uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	h := append(append([]byte(nil), proxyV2Signature...), 0x21, 0x10|transport)
+	h = appendUint16(h, uint16(len(payload)))
+	return append(h, payload...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 56324}
+	dst := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 11), Port: 443}
+	v2 := proxyV2(1, src, dst, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("a.test")},
+		ProxyTLV{Type: ProxyTLVUniqueID, Value: []byte{1, 2, 3}})
+	local := append(append([]byte(nil), proxyV2Signature...), 0x20, 0x00, 0, 0)
+
+	for _, tc := range []struct {
+		name    string
+		data    string
+		allowV1 bool
+		n       int
+		err     error
+		src     string
+		dst     string
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET /", true, 47, nil, "192.168.0.1:56324", "192.168.0.11:443"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n", true, 40, nil, "[2001:db8::1]:1", "[2001:db8::2]:2"},
+		{"v1 unknown", "PROXY UNKNOWN ff ff\r\n", true, 21, nil, "", ""},
+		{"v1 disallowed", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", false, 0, errNotProxy, "", ""},
+		{"v1 partial signature", "PRO", true, 0, nil, "", ""},
+		{"v1 incomplete", "PROXY TCP4 192.168.0.1", true, 0, nil, "", ""},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 192.168.0.11 1 2\r\n", true, 0, ErrProxyHeader, "", ""},
+		{"v1 bad port", "PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n", true, 0, ErrProxyHeader, "", ""},
+		{"v1 too long", "PROXY " + string(bytes.Repeat([]byte("x"), 110)), true, 0, ErrProxyHeader, "", ""},
+		{"v2", string(v2) + "hello", false, len(v2), nil, src.String(), dst.String()},
+		{"v2 local", string(local), false, 16, nil, "", ""},
+		{"v2 proxy unspec", string(proxyV2Signature) + "\x21\x00\x00\x00", false, 16, nil, "", ""},
+		{"v2 partial signature", string(proxyV2Signature[:5]), false, 0, nil, "", ""},
+		{"v2 incomplete", string(v2[:len(v2)-1]), false, 0, nil, "", ""},
+		{"v2 bad version", string(proxyV2Signature) + "\x11\x11\x00\x00", false, 0, ErrProxyHeader, "", ""},
+		{"v2 truncated tlv", string(proxyV2(1, src, dst)[:16-2]) + "\x00\x0e" + string(v2[16:28]) + "\x02\x00", false, 0, ErrProxyHeader, "", ""},
+		{"none", "GET / HTTP/1.1\r\n", true, 0, errNotProxy, "", ""},
+	} {
+		h, n, err := parseProxyHeader([]byte(tc.data), tc.allowV1)
+		if n != tc.n || err != tc.err {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tc.name, n, err, tc.n, tc.err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if tc.src == "" {
+			if !h.Local || h.Source != nil {
+				t.Errorf("%s: a local header without addresses is expected, got %+v", tc.name, h)
+			}
+			continue
+		}
+		if h.Local || h.Source.String() != tc.src || h.Destination.String() != tc.dst {
+			t.Errorf("%s: unexpected addresses %v -> %v", tc.name, h.Source, h.Destination)
+		}
+	}
+
+	h, _, _ := parseProxyHeader(v2, false)
+	if h.Version != 2 || h.Authority() != "a.test" || !bytes.Equal(h.UniqueID(), []byte{1, 2, 3}) {
+		t.Fatalf("unexpected TLVs: %+v", h)
+	}
+	if _, ok := h.Source.(*net.TCPAddr); !ok {
+		t.Fatalf("a stream header should carry TCP addresses, got %T", h.Source)
+	}
+	if h, _, _ = parseProxyHeader(proxyV2(2, src, dst), false); h == nil {
+		t.Fatal("failed to parse a datagram header")
+	} else if _, ok := h.Source.(*net.UDPAddr); !ok {
+		t.Fatalf("a datagram header should carry UDP addresses, got %T", h.Source)
+	}
+}
+
+// proxyOpened is what a connection looks like on opening.
+type proxyOpened struct {
+	remote, local string
+	header        *ProxyHeader
+}
+
+type testProxyServer struct {
+	*EventServer
+	opened chan proxyOpened
+}
+
+func (es *testProxyServer) OnOpened(c Conn) (out []byte, action Action) {
+	es.opened <- proxyOpened{remote: c.RemoteAddr().String(), local: c.LocalAddr().String(), header: c.ProxyHeader()}
+	return
+}
+
+func (es *testProxyServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.ProxyHeader() != nil {
+		out = []byte(c.RemoteAddr().String() + " " + string(frame))
+	} else {
+		out = append([]byte(nil), frame...)
+	}
+	return
+}
+
+func TestProxyProtocol(t *testing.T) {
+	es := &testProxyServer{EventServer: new(EventServer), opened: make(chan proxyOpened, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithProxyProtocol(ProxyProtocolRequired),
+		WithListener("tcp://127.0.0.1:0", WithProxyProtocol(ProxyProtocolOptional)))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addrs := h.Server().Addrs
+
+	// The header is split across the writes and followed by the data in the last one.
+	src := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40000}
+	dst := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 443}
+	header := proxyV2(1, src, dst, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("a.test")})
+	c, err := net.Dial("tcp", addrs[0].String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write(header[:10])
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-es.opened:
+		t.Fatal("the connection should not be opened before the header")
+	default:
+	}
+	_, _ = c.Write(append(header[10:], "hello"...))
+	oc := <-es.opened
+	if oc.remote != src.String() || oc.local != dst.String() {
+		t.Fatalf("unexpected addresses on opening: %s -> %s", oc.remote, oc.local)
+	}
+	if oc.header == nil || oc.header.Authority() != "a.test" {
+		t.Fatalf("unexpected header: %+v", oc.header)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	want := src.String() + " hello"
+	buf := make([]byte, len(want))
+	if _, err = io.ReadFull(c, buf); err != nil || string(buf) != want {
+		t.Fatalf("unexpected echo: %q, %v", buf, err)
+	}
+
+	// The connections without the header are closed when it is required.
+	nc, err := net.Dial("tcp", addrs[0].String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer nc.Close()
+	_, _ = nc.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = nc.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("the connection without a header should be closed, got: %v", err)
+	}
+
+	// The header is optional on the extra listener.
+	testEcho(t, "tcp", addrs[1].String(), "hello plain")
+	if oc = <-es.opened; oc.header != nil {
+		t.Fatal("a connection without a header should have none")
+	}
+	testRequest(t, "tcp", addrs[1].String(), "PROXY TCP4 203.0.113.8 198.51.100.1 40001 80\r\nhello v1",
+		"203.0.113.8:40001 hello v1")
+	if oc = <-es.opened; oc.header == nil || oc.header.Version != 1 {
+		t.Fatalf("unexpected header: %+v", oc.header)
+	}
+	waitFor(t, "the connections to be closed", func() bool { return h.Stats().Connections == 1 })
+}
+
+func TestProxyHeaderTimeout(t *testing.T) {
+	es := &testProxyServer{EventServer: new(EventServer), opened: make(chan proxyOpened, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithProxyProtocol(ProxyProtocolRequired),
+		WithProxyHeaderTimeout(50*time.Millisecond),
+		WithListener("tcp://127.0.0.1:0", WithProxyProtocol(ProxyProtocolOptional)))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addrs := h.Server().Addrs
+
+	// The connections without the header are closed once the wait has timed out when it is required.
+	c, err := net.Dial("tcp", addrs[0].String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("the connection without a header should be closed, got: %v", err)
+	}
+
+	// They are opened without a header when it is optional, as the client may wait for the server to speak first,
+	// and what looked like the start of a header is read as the data.
+	oc, err := net.Dial("tcp", addrs[1].String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer oc.Close()
+	_, _ = oc.Write([]byte("PRO"))
+	select {
+	case opened := <-es.opened:
+		if opened.header != nil || opened.remote != oc.LocalAddr().String() {
+			t.Fatalf("the connection should be opened without a header: %+v", opened)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the connection should be opened once the wait has timed out")
+	}
+	_ = oc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(oc, buf); err != nil || string(buf) != "PRO" {
+		t.Fatalf("unexpected echo: %q, %v", buf, err)
+	}
+}
+
+func TestProxyProtocolUDP(t *testing.T) {
+	h, err := Start(&testProxyServer{EventServer: new(EventServer)}, "udp://127.0.0.1:0",
+		WithProxyProtocol(ProxyProtocolRequired))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err := net.Dial("udp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	// The datagrams without the header are dropped.
+	_, _ = c.Write([]byte("dropped"))
+	src := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 5353}
+	dst := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 53}
+	_, _ = c.Write(append(proxyV2(2, src, dst), "hello"...))
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if want := "203.0.113.9:5353 hello"; string(buf[:n]) != want {
+		t.Fatalf("unexpected reply: %q, want %q", buf[:n], want)
+	}
+}
@@ -0,0 +1,101 @@
+// +build linux
+
+package netti
+
+import (
+	"netti/internal/timingwheel"
+	"time"
+)
+
+// defaultProxyHeaderTimeout bounds the wait for the PROXY protocol header if Options.ProxyHeaderTimeout is not set.
+const defaultProxyHeaderTimeout = 10 * time.Second
+
+// proxyWait is the state of an accepted connection waiting for its PROXY protocol header.
+type proxyWait struct {
+	buf   []byte             // data read so far
+	timer *timingwheel.Timer // timeout of the header
+}
+
+// loopWaitProxy waits for the PROXY protocol header of an accepted connection polled by the loop,
+// the connection is opened once the header has been read, or without a header once the wait has timed out
+// if the header is optional.
+func (el *eventloop) loopWaitProxy(c *conn) error {
+	w := c.proxyWait
+	timeout := c.svr.opts.ProxyHeaderTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	w.timer = timingwheel.NewTimer(timeout, false, func() error {
+		delete(c.timers, w.timer)
+		if el.connections[c.fd] != c || c.proxyWait != w {
+			return nil
+		}
+		if c.ln.proxyMode == ProxyProtocolOptional {
+			// What has been read so far is the start of the data rather than a header.
+			return el.loopProxyDone(c, nil, w.buf)
+		}
+		return el.loopCloseConn(c, ErrProxyHeaderTimeout)
+	})
+	c.addTimer(w.timer)
+	return nil
+}
+
+// loopReadProxy reads the PROXY protocol header at the start of an accepted connection, the connection takes the
+// addresses carried by the header and is opened, the data following the header is read as usual.
+func (el *eventloop) loopReadProxy(c *conn, data []byte) error {
+	w := c.proxyWait
+	w.buf = append(w.buf, data...)
+	h, n, err := parseProxyHeader(w.buf, true)
+	switch {
+	case err == errNotProxy && c.ln.proxyMode == ProxyProtocolOptional:
+	case err != nil:
+		return el.loopCloseConn(c, ErrProxyHeader)
+	case n == 0:
+		return nil // incomplete
+	}
+	return el.loopProxyDone(c, h, w.buf[n:])
+}
+
+// loopProxyDone opens the connection which has stopped waiting for the PROXY protocol header with the header,
+// nil if it has none, and reads the rest of the data read so far as usual.
+func (el *eventloop) loopProxyDone(c *conn, h *ProxyHeader, rest []byte) error {
+	c.removeTimer(c.proxyWait.timer)
+	c.proxyWait = nil
+	c.setProxyHeader(h)
+
+	if err := el.loopOpen(c); err != nil || el.connections[c.fd] != c {
+		return err
+	}
+	if len(rest) > 0 {
+		if c.tls != nil {
+			return el.loopReadTLS(c, rest)
+		}
+		c.buffer = rest
+		return el.loopReact(c)
+	}
+	return nil
+}
+
+// readProxyUDP strips the PROXY protocol v2 header at the start of a datagram, it reports false if the datagram
+// should be dropped, as the header is malformed or missing while required.
+func (ln *listener) readProxyUDP(data []byte) (*ProxyHeader, []byte, bool) {
+	h, n, err := parseProxyHeader(data, false)
+	if err == errNotProxy && ln.proxyMode == ProxyProtocolOptional {
+		return nil, data, true
+	}
+	if err != nil || n == 0 {
+		return nil, nil, false
+	}
+	return h, data[n:], true
+}
+
+// setProxyHeader sets the PROXY protocol header of the connection, taking the addresses carried by it.
+func (c *conn) setProxyHeader(h *ProxyHeader) {
+	if c.proxy = h; h != nil && !h.Local {
+		c.remoteAddr, c.localAddr = h.Source, h.Destination
+	}
+}
+
+func (c *conn) ProxyHeader() *ProxyHeader {
+	return c.proxy
+}
@@ -0,0 +1,94 @@
+package netti
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the bytes transferred per second with a token bucket holding up to one second worth
+// of bytes, it is safe to share between event-loops.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	bucket tokenBucket
+}
+
+// newRateLimiter creates a limiter of bps bytes per second, it returns nil if bps is not positive.
+func newRateLimiter(bps int64) *rateLimiter {
+	if bps <= 0 {
+		return nil
+	}
+	burst := bps
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return &rateLimiter{
+		rate:   float64(bps),
+		burst:  int(burst),
+		bucket: tokenBucket{tokens: float64(burst), last: time.Now()},
+	}
+}
+
+// available returns the number of bytes that can be transferred at now.
+func (l *rateLimiter) available(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bucket.refill(now, l.rate, l.burst)
+	return int(l.bucket.tokens)
+}
+
+// take takes n bytes out of the bucket, it may be overdrawn by the concurrent users.
+func (l *rateLimiter) take(n int) {
+	l.mu.Lock()
+	l.bucket.tokens -= float64(n)
+	l.mu.Unlock()
+}
+
+// wait returns the duration until a byte can be transferred.
+func (l *rateLimiter) wait(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bucket.refill(now, l.rate, l.burst)
+	if l.bucket.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.bucket.tokens) / l.rate * float64(time.Second))
+}
+
+// quota returns how many of n bytes can be transferred at now under the limiters, the nil ones are ignored.
+func quota(n int, now time.Time, limiters ...*rateLimiter) int {
+	for _, l := range limiters {
+		if l != nil {
+			if a := l.available(now); a < n {
+				n = a
+			}
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// consume takes n bytes out of the limiters, the nil ones are ignored.
+func consume(n int, limiters ...*rateLimiter) {
+	for _, l := range limiters {
+		if l != nil {
+			l.take(n)
+		}
+	}
+}
+
+// refillWait returns the duration until a byte can be transferred under all the limiters.
+func refillWait(now time.Time, limiters ...*rateLimiter) (d time.Duration) {
+	for _, l := range limiters {
+		if l != nil {
+			if w := l.wait(now); w > d {
+				d = w
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,98 @@
+// +build linux
+
+package netti
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// envInheritedListeners is the environment variable which holds the keys of the listeners handed over
+// from the parent process, the i-th listener is inherited as the file descriptor 3+i.
+const envInheritedListeners = "NETTI_INHERITED_LISTENERS"
+
+// inheritedListenersSep separates the keys of the inherited listeners.
+const inheritedListenersSep = ";"
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[string]*os.File
+)
+
+// loadInheritedListeners collects the listeners handed over from the parent process, the environment
+// variable is unset to prevent the child processes from taking them for their own.
+func loadInheritedListeners() {
+	keys := os.Getenv(envInheritedListeners)
+	if keys == "" {
+		return
+	}
+	sniffError(os.Unsetenv(envInheritedListeners))
+	inherited = make(map[string]*os.File)
+	for i, key := range strings.Split(keys, inheritedListenersSep) {
+		fd := 3 + i
+		unix.CloseOnExec(fd)
+		inherited[key] = os.NewFile(uintptr(fd), "inherited-"+strconv.Itoa(fd))
+	}
+}
+
+// inheritedListener returns the file of the listener with the given key handed over from
+// the parent process, or nil if there is none. Every listener can be taken only once.
+func inheritedListener(key string) *os.File {
+	inheritOnce.Do(loadInheritedListeners)
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	f := inherited[key]
+	delete(inherited, key)
+	return f
+}
+
+// hotRestart starts a new process of the same binary inheriting all the listeners and then signals a shutdown.
+func (svr *server) hotRestart() error {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if svr.isShutdown() {
+		return ErrServerShutdown
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// Pass the raw file descriptors since (*os.File).Fd would put the listeners into blocking mode.
+	keys := make([]string, len(svr.listeners))
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for i, ln := range svr.listeners {
+		keys[i] = ln.key
+		files = append(files, uintptr(ln.fd))
+	}
+	env := []string{envInheritedListeners + "=" + strings.Join(keys, inheritedListenersSep)}
+	if idx := preforkWorkerIndex(); idx >= 0 {
+		// The new process takes over as the same prefork worker.
+		env = append(env, envPreforkWorker+"="+strconv.Itoa(idx))
+	}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envInheritedListeners+"=") {
+			env = append(env, kv)
+		}
+	}
+	if _, err = syscall.ForkExec(path, os.Args, &syscall.ProcAttr{Env: env, Files: files}); err != nil {
+		return err
+	}
+
+	// The unix socket files are in use by the new process now.
+	for _, ln := range svr.listeners {
+		if ul, ok := ln.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		ln.keepFile = true
+	}
+	go svr.signalShutdown()
+	return nil
+}
@@ -0,0 +1,91 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// envTestHotRestartChild holds the addresses to be adopted by the child process of TestHotRestart.
+const envTestHotRestartChild = "NETTI_TEST_HOT_RESTART_CHILD"
+
+type testHotRestartChild struct {
+	*EventServer
+}
+
+func (es *testHotRestartChild) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = append([]byte("child:"), frame...)
+	return
+}
+
+func (es *testHotRestartChild) OnClosed(c Conn, err error) (action Action) {
+	if c.LocalAddr().Network() == "tcp" {
+		action = Shutdown
+	}
+	return
+}
+
+func runHotRestartChild(t *testing.T, addrs []string) {
+	inheritOnce.Do(loadInheritedListeners)
+	for fd := 3; fd < 3+len(inherited); fd++ {
+		if flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil || flags&unix.FD_CLOEXEC == 0 {
+			t.Fatalf("inherited listener %d should be close-on-exec, flags: %#x, err: %v", fd, flags, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ServeContext(ctx, &testHotRestartChild{new(EventServer)}, addrs[0],
+		WithListener(addrs[1]), WithListener(addrs[2])); err != nil {
+		t.Fatalf("child failed to serve: %v", err)
+	}
+}
+
+func TestHotRestart(t *testing.T) {
+	if addrs := os.Getenv(envTestHotRestartChild); addrs != "" {
+		runHotRestartChild(t, strings.Split(addrs, ","))
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "netti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "netti.sock")
+	addrs := []string{"tcp://127.0.0.1:0", "unix://" + sock, "udp://127.0.0.1:0"}
+
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, addrs[0], WithListener(addrs[1]), WithListener(addrs[2]), WithDrainTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	lnAddrs := h.Server().Addrs
+	testEcho(t, "tcp", lnAddrs[0].String(), "parent")
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHotRestart$"}
+	defer func() { os.Args = args }()
+	_ = os.Setenv(envTestHotRestartChild, strings.Join(addrs, ","))
+	defer os.Unsetenv(envTestHotRestartChild)
+	if err = h.Restart(); err != nil {
+		t.Fatalf("failed to restart: %v", err)
+	}
+	if err = h.Wait(); err != nil {
+		t.Fatalf("unexpected error from Wait: %v", err)
+	}
+	if _, err = os.Stat(sock); err != nil {
+		t.Fatalf("unix socket file should be kept for the child: %v", err)
+	}
+
+	testRequest(t, "udp", lnAddrs[2].String(), "udp", "child:udp")
+	testRequest(t, "unix", sock, "unix", "child:unix")
+	testRequest(t, "tcp", lnAddrs[0].String(), "tcp", "child:tcp")
+}
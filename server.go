@@ -1,6 +1,7 @@
 package netti
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -18,6 +19,11 @@ type Server struct {
 	// with the addr string passed to the Serve function.
 	Addr net.Addr
 
+	// Addrs are the listening addresses of all the listeners, in the order of the addr passed to
+	// the Serve function followed by the ones set up with WithListener, Addrs[0] is Addr.
+	// The LocalAddr of a connection is the address of the listener it comes from.
+	Addrs []net.Addr
+
 	// NumEventLoop is the number of event-loops that the server is using.
 	NumEventLoop int
 
@@ -26,4 +32,138 @@ type Server struct {
 
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
+
+	// Prefork indicates whether the server is running in a prefork worker process.
+	Prefork bool
+
+	// WorkerIndex is the index of the prefork worker process that the server is running in.
+	WorkerIndex int
+}
+
+// Stats are the statistics of the connections of a running server.
+type Stats struct {
+	// Connections is the number of the open connections.
+	Connections int
+
+	// Accepted is the total number of the connections and UDP sessions which have passed the accept filters
+	// and the limits.
+	Accepted uint64
+
+	// Filtered is the total number of the connections and UDP sessions rejected by the accept filters.
+	Filtered uint64
+
+	// Rejected is the total number of the connections and UDP sessions rejected by MaxConnections,
+	// MaxConnectionsPerIP and MaxUDPSessions.
+	Rejected uint64
+}
+
+// ServerHandle is a handle to a server started by Start, it is safe to use from any goroutine.
+type ServerHandle struct {
+	svr     *server
+	prefork *prefork
+	done    chan struct{}
+	err     error
+	cause   *ServerError
+}
+
+// Server returns the information of the running server, it is empty in the prefork master process.
+func (h *ServerHandle) Server() Server {
+	if h.svr == nil {
+		return Server{}
+	}
+	return h.svr.serverInfo()
+}
+
+// Stats returns the statistics of the running server, it is empty in the prefork master process.
+func (h *ServerHandle) Stats() Stats {
+	if h.svr == nil {
+		return Stats{}
+	}
+	return h.svr.stats()
+}
+
+// AddListener starts listening on addr while the server is running, the new listener is served by the
+// same event-loops as the others and opts apply to this listener only, see ListenerConfig.
+func (h *ServerHandle) AddListener(addr string, opts ...Option) (net.Addr, error) {
+	if h.svr == nil {
+		return nil, ErrServerShutdown
+	}
+	ln, err := listen(addr, ListenerConfig{Addr: addr, Options: opts}.options(h.svr.opts))
+	if err != nil {
+		return nil, err
+	}
+	if err = h.svr.addListener(ln); err != nil {
+		ln.close()
+		return nil, err
+	}
+	return ln.lnaddr, nil
+}
+
+// RemoveListener stops listening on the listener with the given address and closes it,
+// the connections accepted from it are kept alive.
+func (h *ServerHandle) RemoveListener(addr net.Addr) error {
+	if h.svr == nil {
+		return ErrListenerNotFound
+	}
+	ln, err := h.svr.removeListener(addr)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ln.detached:
+	case <-h.done:
+	}
+	ln.close()
+	return nil
+}
+
+// Stop signals the server to shut down and waits for all event-loops and connections to be closed,
+// it returns ctx.Err() if ctx is done before the server has been shut down completely.
+// In the prefork master process, Stop sends SIGTERM to the worker processes and waits for them to exit.
+// With a drain timeout set up, the server stops accepting new connections and keeps serving the
+// existing ones until they are closed, the timeout expires or ctx is done, whichever happens first,
+// the remaining connections are then closed with ErrServerShutdown.
+func (h *ServerHandle) Stop(ctx context.Context) error {
+	if h.svr != nil {
+		h.svr.signalShutdownContext(ctx)
+	} else if h.prefork != nil {
+		h.prefork.terminate()
+	}
+	select {
+	case <-h.done:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Restart starts a new process of the same binary with the same arguments and hands over all the listeners
+// to it, then shuts the server down as Stop does without waiting for it. The new process adopts the
+// inherited listeners instead of binding them again when its Serve is called with the same addresses.
+// In a prefork worker process, the new process takes over as the same worker and is supervised by the master.
+func (h *ServerHandle) Restart() error {
+	if h.svr == nil {
+		return ErrServerShutdown
+	}
+	return h.svr.hotRestart()
+}
+
+// Wait blocks until the server has been shut down, it returns a *ServerError if an event-loop fails
+// and nil if the server is shut down by Stop or by a Shutdown returned from the event handler.
+func (h *ServerHandle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Cause blocks until the server has been shut down and returns the *ServerError of the event-loop
+// which shuts the server down, it is nil if the server is shut down by Stop. Unlike Wait it also
+// reports a Shutdown returned from the event handler, with ServerError.Shutdown set.
+func (h *ServerHandle) Cause() *ServerError {
+	<-h.done
+	return h.cause
+}
+
+// Done returns a channel that is closed once the server has been shut down.
+func (h *ServerHandle) Done() <-chan struct{} {
+	return h.done
 }
@@ -13,6 +13,10 @@ func (es *EventServer) OnInitComplete(svr Server) (action Action) {
 	return
 }
 
+// OnShutdown 在服务器关闭的时候触发, 此时所有的事件循环和连接都已经被关闭
+func (es *EventServer) OnShutdown(svr Server) {
+}
+
 // OnOpened 在一个新连接被打开的时候触发, 参数传入为 connection 接口实例, 包含有关连接的本地地址
 // 和远端地址等信息, 使用 out 返回值来进行对连接的数据写入操作
 func (es *EventServer) OnOpened(c Conn) (out []byte, action Action) {
@@ -25,6 +29,11 @@ func (es *EventServer) OnClosed(c Conn, err error) (action Action) {
 	return
 }
 
+// OnIdle 在连接读、写或读写空闲超时的时候触发, 默认不做任何处理, 需要关闭空闲的连接时返回 Close
+func (es *EventServer) OnIdle(c Conn, kind IdleKind) (action Action) {
+	return
+}
+
 // React fires when a connection sends the server data.
 // Invoke c.Read() or c.ReadN(n) within the parameter c to read incoming data from client/connection.
 // Use the out return value to write data to the client/connection.
@@ -0,0 +1,930 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testEchoServer struct {
+	*EventServer
+	shutdown chan Server
+}
+
+func (es *testEchoServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = append([]byte{}, frame...)
+	return
+}
+
+func (es *testEchoServer) OnShutdown(svr Server) {
+	es.shutdown <- svr
+}
+
+func testEcho(t *testing.T, network, addr string, msg string) {
+	testRequest(t, network, addr, msg, msg)
+}
+
+func testRequest(t *testing.T, network, addr string, req, resp string) {
+	c, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial %s://%s: %v", network, addr, err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	if _, err = c.Write([]byte(req)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, len(resp))
+	for n := 0; n < len(buf); {
+		nn, err := c.Read(buf[n:])
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		n += nn
+	}
+	if string(buf) != resp {
+		t.Fatalf("response mismatch, want: %q, got: %q", resp, buf)
+	}
+}
+
+func TestServerStartStop(t *testing.T) {
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	testEcho(t, "tcp", h.Server().Addr.String(), "hello netti")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = h.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	select {
+	case svr := <-es.shutdown:
+		if svr.Addr.String() != h.Server().Addr.String() {
+			t.Fatalf("unexpected server address in OnShutdown: %v", svr.Addr)
+		}
+	default:
+		t.Fatal("OnShutdown was not fired")
+	}
+	if err = h.Wait(); err != nil {
+		t.Fatalf("unexpected error from Wait: %v", err)
+	}
+}
+
+func TestServeContext(t *testing.T) {
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ServeContext(ctx, es, "tcp://127.0.0.1:0", WithReusePort(true))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected error from ServeContext: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeContext did not return after the context was canceled")
+	}
+}
+
+// testStuckServer blocks its event-loop in React until release is closed.
+type testStuckServer struct {
+	*EventServer
+	reacting chan struct{}
+	release  chan struct{}
+}
+
+func (es *testStuckServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	close(es.reacting)
+	<-es.release
+	return
+}
+
+func TestServeContextBounded(t *testing.T) {
+	grace := serveContextGrace
+	serveContextGrace = 100 * time.Millisecond
+	defer func() { serveContextGrace = grace }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	es := &testStuckServer{EventServer: new(EventServer), reacting: make(chan struct{}), release: make(chan struct{})}
+	defer close(es.release)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ServeContext(ctx, es, "tcp://"+addr, WithDrainTimeout(100*time.Millisecond))
+	}()
+	var c net.Conn
+	waitFor(t, "the server to listen", func() bool {
+		c, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+	defer c.Close()
+	_, _ = c.Write([]byte("stuck"))
+	<-es.reacting
+
+	// The stop of a server whose event-loop never returns gives up after DrainTimeout plus the grace period.
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error from ServeContext: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeContext did not return after DrainTimeout plus the grace period")
+	}
+}
+
+type testDrainServer struct {
+	*testEchoServer
+	closed chan error
+}
+
+func (es *testDrainServer) OnClosed(c Conn, err error) (action Action) {
+	es.closed <- err
+	return
+}
+
+func TestServerDrain(t *testing.T) {
+	es := &testDrainServer{
+		testEchoServer: &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)},
+		closed:         make(chan error, 1),
+	}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithDrainTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr := h.Server().Addr.String()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	stopped := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		stopped <- h.Stop(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err = net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		t.Fatal("server should not accept new connections while draining")
+	}
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write while draining: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err = c.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("failed to read while draining: %q, %v", buf, err)
+	}
+
+	if err = <-stopped; err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if time.Since(start) < 300*time.Millisecond {
+		t.Fatal("server stopped before the drain timeout expired")
+	}
+	if err = <-es.closed; err != ErrServerShutdown {
+		t.Fatalf("unexpected close reason: %v", err)
+	}
+}
+
+func TestServerDrainLoopExit(t *testing.T) {
+	h, err := Start(&testShutdownServer{new(EventServer)}, "tcp://127.0.0.1:0", WithDrainTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	idle, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer idle.Close()
+	waitFor(t, "the connection to be opened", func() bool { return h.Stats().Connections == 1 })
+
+	// The idle connection is left on the loop exiting, it is not waited for.
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	start := time.Now()
+	_, _ = c.Write([]byte("shutdown"))
+	_ = h.Wait()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("the connections of the exited loop were drained for %v", d)
+	}
+}
+
+func TestServerDrainDetach(t *testing.T) {
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithNumEventLoop(4), WithDrainTimeout(5*time.Second),
+		WithListener("tcp://127.0.0.1:0", WithReusePort(true)))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	waitFor(t, "the connection to be opened", func() bool { return h.Stats().Connections == 1 })
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- h.Stop(context.Background())
+	}()
+	waitFor(t, "the listeners to be closed", func() bool {
+		for _, addr := range h.Server().Addrs {
+			cc, err := net.DialTimeout("tcp", addr.String(), 100*time.Millisecond)
+			if err == nil {
+				_ = cc.Close()
+				return false
+			}
+		}
+		return true
+	})
+
+	// The listeners are closed only after every loop polling them has removed them within the loop.
+	for _, ln := range h.svr.listeners {
+		for _, el := range h.svr.loops(ln) {
+			listeners := make(chan int, 1)
+			_ = el.poller.Trigger(func() error {
+				listeners <- len(el.listeners)
+				return nil
+			})
+			if n := <-listeners; n != 0 {
+				t.Errorf("event-loop %d still has %d listeners while draining", el.idx, n)
+			}
+		}
+	}
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write while draining: %v", err)
+	}
+	if _, err = c.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("failed to read while draining: %q, %v", buf, err)
+	}
+	_ = c.Close()
+	if err = <-stopped; err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+}
+
+func TestServerMultipleListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "netti.sock")
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0",
+		WithNumEventLoop(2),
+		WithListener("unix://"+sock, WithCodec(new(LineBasedFrameCodec))),
+		WithListener("udp://127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	addrs := h.Server().Addrs
+	if len(addrs) != 3 || addrs[0] != h.Server().Addr {
+		t.Fatalf("unexpected listening addresses: %v", addrs)
+	}
+	testEcho(t, "tcp", addrs[0].String(), "hello tcp")
+	testEcho(t, "unix", sock, "hello unix\n")
+	testEcho(t, "udp", addrs[2].String(), "hello udp")
+}
+
+func TestServerAddRemoveListener(t *testing.T) {
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithReusePort(true), WithNumEventLoop(2))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	addr, err := h.AddListener("tcp://127.0.0.1:0", WithReusePort(false))
+	if err != nil {
+		t.Fatalf("failed to add listener: %v", err)
+	}
+	if addrs := h.Server().Addrs; len(addrs) != 2 || addrs[1].String() != addr.String() {
+		t.Fatalf("unexpected listening addresses: %v", addrs)
+	}
+	testEcho(t, "tcp", addr.String(), "hello new listener")
+
+	c, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if _, err = c.Read(buf); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if err = h.RemoveListener(addr); err != nil {
+		t.Fatalf("failed to remove listener: %v", err)
+	}
+	if err = h.RemoveListener(addr); err != ErrListenerNotFound {
+		t.Fatalf("unexpected error of removing a removed listener: %v", err)
+	}
+	if _, err = net.DialTimeout("tcp", addr.String(), 100*time.Millisecond); err == nil {
+		t.Fatal("removed listener should not accept new connections")
+	}
+	if _, err = c.Write([]byte("pong")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if _, err = c.Read(buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("connection of a removed listener should be kept alive: %q, %v", buf, err)
+	}
+	testEcho(t, "tcp", h.Server().Addr.String(), "hello old listener")
+}
+
+type testShutdownServer struct {
+	*EventServer
+}
+
+func (es *testShutdownServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	action = Shutdown
+	return
+}
+
+func TestServerError(t *testing.T) {
+	h, err := Start(&testShutdownServer{new(EventServer)}, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write([]byte("shutdown"))
+	if err = h.Wait(); err != nil {
+		t.Fatalf("a shutdown from event handler should not be an error, got: %v", err)
+	}
+	if cause := h.Cause(); cause == nil || !cause.Shutdown || cause.Loop < 0 || !errors.Is(cause, ErrServerShutdown) {
+		t.Fatalf("unexpected cause of a shutdown from event handler: %#v", cause)
+	}
+
+	h, err = Start(&testShutdownServer{new(EventServer)}, "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	errFault := errors.New("fault")
+	h.svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		if i == 1 {
+			_ = el.poller.Trigger(func() error { return errFault })
+		}
+		return true
+	})
+	serr, ok := h.Wait().(*ServerError)
+	if !ok || serr.Loop != 1 || serr.Shutdown || !errors.Is(serr, errFault) || errors.Is(serr, ErrServerShutdown) {
+		t.Fatalf("unexpected error of a failed event-loop: %#v", serr)
+	}
+	if cause := h.Cause(); cause != serr {
+		t.Fatalf("the cause of a failed event-loop should be the error of Wait, got: %#v", cause)
+	}
+
+	h, err = Start(&testShutdownServer{new(EventServer)}, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if err = h.Stop(context.Background()); err != nil || h.Cause() != nil {
+		t.Fatalf("a stop should have neither an error nor a cause, got: %v, %#v", err, h.Cause())
+	}
+}
+
+type testTimerServer struct {
+	*EventServer
+	ticks int
+}
+
+func (es *testTimerServer) OnOpened(c Conn) (out []byte, action Action) {
+	stopped := c.AfterFunc(10*time.Millisecond, func(c Conn) { _ = c.AsyncWrite([]byte("stopped")) })
+	stopped.Stop()
+	var n int
+	var every Timer
+	every = c.Every(10*time.Millisecond, func(c Conn) {
+		if n++; n == 3 {
+			every.Stop()
+		}
+		_ = c.AsyncWrite([]byte("tick"))
+	})
+	c.AfterFunc(100*time.Millisecond, func(c Conn) { _ = c.Close() })
+	return
+}
+
+func (es *testTimerServer) Tick() (delay time.Duration, action Action) {
+	if es.ticks++; es.ticks == 3 {
+		action = Shutdown
+	}
+	return 10 * time.Millisecond, action
+}
+
+func TestConnTimers(t *testing.T) {
+	h, err := Start(&testTimerServer{EventServer: new(EventServer)}, "tcp://127.0.0.1:0",
+		WithNumEventLoop(2))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if string(data) != "tickticktick" {
+		t.Fatalf("unexpected data written by the timers: %q", data)
+	}
+}
+
+type testTimerStopServer struct {
+	*EventServer
+}
+
+func (es *testTimerStopServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if prev, ok := c.Context().([]Timer); ok {
+		for _, t := range prev {
+			t.Stop()
+		}
+	}
+	nop := func(c Conn) {}
+	c.SetContext([]Timer{c.AfterFunc(time.Hour, nop), c.Every(time.Hour, nop)})
+	cc := c.(*conn)
+	out = []byte(strconv.Itoa(len(cc.timers)) + " " + strconv.Itoa(cc.loop.wheel.Len()))
+	return
+}
+
+func TestConnTimersStopped(t *testing.T) {
+	h, err := Start(&testTimerStopServer{new(EventServer)}, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	// The timers stopped by the previous requests are taken off the connection and the wheel.
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for i := 0; i < 10; i++ {
+		want := "2 2"
+		if i == 0 {
+			want = "0 0"
+		}
+		if _, err = c.Write([]byte("request")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if n, err := c.Read(buf); err != nil || string(buf[:n]) != want {
+			t.Fatalf("request %d: %q, %v, want: %q", i, buf[:n], err, want)
+		}
+	}
+}
+
+func TestServerTick(t *testing.T) {
+	es := &testTimerServer{EventServer: new(EventServer)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithTicker(true))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the server should be shut down by Tick")
+	}
+	if err = h.Wait(); err != nil || es.ticks != 3 {
+		t.Fatalf("unexpected error of a shutdown from Tick: %v, ticks: %d", err, es.ticks)
+	}
+}
+
+type testIdleServer struct {
+	*EventServer
+	idle   chan IdleKind
+	closed chan error
+}
+
+func (es *testIdleServer) OnIdle(c Conn, kind IdleKind) (action Action) {
+	es.idle <- kind
+	return Close
+}
+
+func (es *testIdleServer) OnClosed(c Conn, err error) (action Action) {
+	es.closed <- err
+	return
+}
+
+func TestConnIdle(t *testing.T) {
+	es := &testIdleServer{EventServer: new(EventServer), idle: make(chan IdleKind, 1), closed: make(chan error, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithIdleTimeout(50*time.Millisecond, 0, 0),
+		WithFirstFrameTimeout(time.Second), WithCodec(NewDelimiterBasedFrameCodec('\n')))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	// A connection sending complete frames keeps alive until it stops sending.
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	for i := 0; i < 4; i++ {
+		_, _ = c.Write([]byte("ping\n"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case kind := <-es.idle:
+		t.Fatalf("a busy connection is reported %v", kind)
+	default:
+	}
+	select {
+	case kind := <-es.idle:
+		if kind != ReaderIdle {
+			t.Fatalf("unexpected idle kind: %v", kind)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnIdle was not fired")
+	}
+	<-es.closed
+
+	// A connection never completing a frame is closed at the first frame deadline.
+	h, err = Start(es, "tcp://127.0.0.1:0", WithFirstFrameTimeout(50*time.Millisecond),
+		WithCodec(NewDelimiterBasedFrameCodec('\n')))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err = net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write([]byte("incomplete"))
+	select {
+	case err = <-es.closed:
+		if err != ErrFirstFrameTimeout {
+			t.Fatalf("unexpected error closing a slow connection: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the slow connection was not closed")
+	}
+}
+
+type testTimerCountServer struct {
+	*EventServer
+}
+
+func (es *testTimerCountServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = []byte(strconv.Itoa(len(c.(*conn).timers)))
+	return
+}
+
+func TestConnIdleDefaults(t *testing.T) {
+	if action := new(EventServer).OnIdle(nil, AllIdle); action != None {
+		t.Fatalf("idle connections should be left open by default, got: %v", action)
+	}
+
+	// The first frame deadline is taken off the connection once it has passed.
+	h, err := Start(&testTimerCountServer{new(EventServer)}, "tcp://127.0.0.1:0",
+		WithFirstFrameTimeout(20*time.Millisecond), WithIdleTimeout(0, 0, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	for _, want := range []string{"2", "1"} {
+		if _, err = c.Write([]byte("ping")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if n, err := c.Read(buf); err != nil || string(buf[:n]) != want {
+			t.Fatalf("unexpected number of timers: %q, %v, want: %s", buf[:n], err, want)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+type testDeadlineServer struct {
+	*testIdleServer
+}
+
+func (es *testDeadlineServer) OnOpened(c Conn) (out []byte, action Action) {
+	_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	return
+}
+
+func (es *testDeadlineServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The client does not read, a large response is left in the outbound buffer.
+	_ = c.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	_ = c.SetReadDeadline(time.Time{})
+	return make([]byte, 16<<20), None
+}
+
+func TestConnDeadline(t *testing.T) {
+	es := &testDeadlineServer{&testIdleServer{EventServer: new(EventServer), closed: make(chan error, 1)}}
+	h, err := Start(es, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	for _, want := range []error{ErrReadTimeout, ErrWriteTimeout} {
+		c, err := net.Dial("tcp", h.Server().Addr.String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		if want == ErrWriteTimeout {
+			_, _ = c.Write([]byte("request"))
+		}
+		select {
+		case err = <-es.closed:
+			var ne net.Error
+			if err != want || !errors.As(err, &ne) || !ne.Timeout() {
+				t.Fatalf("unexpected error closing the connection, want: %v, got: %v", want, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the connection was not closed with %v", want)
+		}
+		_ = c.Close()
+	}
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	dial := func(addr string) net.Conn {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		_ = c.SetDeadline(time.Now().Add(time.Second))
+		return c
+	}
+	// roundtrip reports whether the connection is served, reading the rejection message if not.
+	roundtrip := func(c net.Conn, reject string) bool {
+		_, _ = c.Write([]byte("ping"))
+		buf, _ := ioutil.ReadAll(io.LimitReader(c, 4))
+		switch string(buf) {
+		case "ping":
+			return true
+		case reject:
+			return false
+		}
+		t.Fatalf("unexpected response: %q", buf)
+		return false
+	}
+
+	h, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0", WithNumEventLoop(2),
+		WithMaxConnections(2), WithConnLimitPolicy(RejectConnection, []byte("busy")))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr := h.Server().Addr.String()
+	c1, c2, c3 := dial(addr), dial(addr), dial(addr)
+	if !roundtrip(c1, "busy") || !roundtrip(c2, "busy") || roundtrip(c3, "busy") {
+		t.Fatal("the connection beyond the limit should be rejected")
+	}
+	_ = c1.Close()
+	time.Sleep(50 * time.Millisecond)
+	if c4 := dial(addr); !roundtrip(c4, "busy") {
+		t.Fatal("a connection should be accepted after another one is closed")
+	}
+	_ = h.Stop(context.Background())
+
+	h, err = Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0",
+		WithMaxConnectionsPerIP(1))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr = h.Server().Addr.String()
+	if c1, c2 = dial(addr), dial(addr); !roundtrip(c1, "") || roundtrip(c2, "") {
+		t.Fatal("the connection beyond the limit per IP should be rejected")
+	}
+	_ = h.Stop(context.Background())
+
+	h, err = Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0",
+		WithMaxConnections(1), WithConnLimitPolicy(PauseAccepting, nil))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr = h.Server().Addr.String()
+	c1 = dial(addr)
+	if !roundtrip(c1, "") {
+		t.Fatal("the first connection should be served")
+	}
+	c2 = dial(addr)
+	_, _ = c2.Write([]byte("ping"))
+	_ = c2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := c2.Read(make([]byte, 4)); n != 0 || err == nil {
+		t.Fatal("the connection beyond the limit should be left in the backlog")
+	}
+	_ = c1.Close()
+	_ = c2.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(c2, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("the connection should be served once the listener is resumed, got: %q, %v", buf, err)
+	}
+}
+
+func TestServerAcceptFilter(t *testing.T) {
+	deny, err := NewCIDRFilter(nil, []string{"127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://0.0.0.0:0",
+		WithAcceptFilter(deny))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	_, port, _ := net.SplitHostPort(h.Server().Addr.String())
+	testEcho(t, "tcp", "127.0.0.1:"+port, "allowed")
+
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, Timeout: time.Second}
+	c, err := d.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	if n, _ := c.Read(make([]byte, 1)); n != 0 {
+		t.Fatal("the denied connection should be closed")
+	}
+	if stats := h.Stats(); stats.Accepted != 1 || stats.Filtered != 1 || stats.Rejected != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+type testRateLimitServer struct {
+	*EventServer
+	received chan int
+}
+
+func (es *testRateLimitServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetRateLimit(0, 1<<20)
+	return
+}
+
+func (es *testRateLimitServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "download" {
+		return make([]byte, 3<<19), None
+	}
+	es.received <- len(frame)
+	return
+}
+
+func TestConnRateLimit(t *testing.T) {
+	es := &testRateLimitServer{EventServer: new(EventServer), received: make(chan int, 1024)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithRateLimit(1<<20, 0))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+
+	// Up to a second worth of bytes goes in a burst, the rest at the rate of 1MB/s.
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	start := time.Now()
+	go func() { _, _ = c.Write(make([]byte, 3<<19)) }()
+	for n := 0; n < 3<<19; {
+		select {
+		case nn := <-es.received:
+			n += nn
+		case <-time.After(3 * time.Second):
+			t.Fatalf("only %d bytes are received", n)
+		}
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("the reads are not limited, took %v", d)
+	}
+
+	c, err = net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	start = time.Now()
+	_, _ = c.Write([]byte("download"))
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = io.ReadFull(c, make([]byte, 3<<19)); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("the writes are not limited, took %v", d)
+	}
+}
+
+func TestEventLoopGroup(t *testing.T) {
+	g, err := NewEventLoopGroup(WithNumEventLoop(2))
+	if err != nil {
+		t.Fatalf("failed to start event-loop group: %v", err)
+	}
+	defer g.Stop(context.Background())
+
+	h1, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0",
+		WithEventLoopGroup(g))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	h2, err := Start(&testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}, "tcp://127.0.0.1:0",
+		WithEventLoopGroup(g), WithReusePort(true), WithNumEventLoop(4))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	h3, err := Start(&testShutdownServer{new(EventServer)}, "tcp://127.0.0.1:0", WithEventLoopGroup(g))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if n := h2.Server().NumEventLoop; n != 2 {
+		t.Fatalf("the server should run on the event-loops of the group, got: %d", n)
+	}
+	ch := &testClientHandler{EventServer: new(EventServer), opened: make(chan Conn, 1), frames: make(chan string, 1)}
+	cli, err := NewClient(ch, WithEventLoopGroup(g))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	c, err := cli.Dial("tcp://" + h1.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	<-ch.opened
+	_ = c.AsyncWrite([]byte("hello"))
+	if frame := <-ch.frames; frame != "hello" {
+		t.Fatalf("unexpected echo: %q", frame)
+	}
+	testEcho(t, "tcp", h2.Server().Addr.String(), "hello shared loops")
+	if n := h1.Stats().Connections; n != 1 {
+		t.Fatalf("the connections of the other servers and clients should not be counted, got: %d", n)
+	}
+
+	// A shutdown from the event handler shuts down that server only.
+	sc, err := net.Dial("tcp", h3.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer sc.Close()
+	_, _ = sc.Write([]byte("shutdown"))
+	if err = h3.Wait(); err != nil {
+		t.Fatalf("a shutdown from event handler should not be an error, got: %v", err)
+	}
+	if cause := h3.Cause(); cause == nil || !cause.Shutdown || cause.Loop < 0 || !errors.Is(cause, ErrServerShutdown) {
+		t.Fatalf("unexpected cause of a shutdown from event handler: %#v", cause)
+	}
+	if err = h1.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	testEcho(t, "tcp", h2.Server().Addr.String(), "hello again")
+
+	if err = g.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop event-loop group: %v", err)
+	}
+	for _, done := range []<-chan struct{}{h2.Done(), cli.Done()} {
+		select {
+		case <-done:
+		default:
+			t.Fatal("the servers and clients should be shut down with the group")
+		}
+	}
+	if _, err = NewClient(ch, WithEventLoopGroup(g)); err != ErrEventLoopGroupStopped {
+		t.Fatalf("starting a client on a stopped group should fail, got: %v", err)
+	}
+}
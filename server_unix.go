@@ -3,165 +3,480 @@
 package netti
 
 import (
-	"netti/internal/netpoll"
-	"runtime"
+	"context"
+	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// drainCheckInterval is the interval of checking whether all connections have been closed while draining.
+const drainCheckInterval = 10 * time.Millisecond
+
+// signalDrainTimeout is the drain timeout of a shutdown on SIGTERM or SIGINT if DrainTimeout is not set.
+const signalDrainTimeout = 30 * time.Second
+
+const (
+	timerTick      = 10 * time.Millisecond // resolution of the timers of an event-loop
+	timerWheelSize = 512                   // number of slots of the timing wheel of an event-loop
+)
+
 // server .
 type server struct {
-	ln               *listener          // all the listeners
-	wg               sync.WaitGroup     // event-loop close WaitGroup
-	opts             *Options           // options with server
-	once             sync.Once          // make sure only signalShutdown once
-	cond             *sync.Cond         // shutdown signaler
-	codec            ICodec             // codec for TCP stream
-	logger           Logger             // customized logger for logging info
-	ticktock         chan time.Duration // ticker channel
-	mainLoop         *eventloop         // main loop for accepting connections
-	eventHandler     EventHandler       // 时间处理回调
-	subLoopGroup     IEventLoopGroup    // 循环处理事件
-	subLoopGroupSize int                // 子事件循环器大小
+	accepted         uint64          // number of the accepted connections, accessed atomically
+	filtered         uint64          // number of the connections rejected by the filters, accessed atomically
+	rejected         uint64          // number of the connections rejected by the limits, accessed atomically
+	connCount        int32           // number of the open connections, accessed atomically
+	sessions         int32           // number of the open UDP sessions, accessed atomically
+	detached         int32           // set once the server has been detached from the event-loops, accessed atomically
+	mu               sync.Mutex      // guards listeners, info and mainLoop while the server is running
+	listeners        []*listener     // all the listeners
+	wg               sync.WaitGroup  // event-loop close WaitGroup
+	opts             *Options        // options with server
+	info             Server          // server information passed to the event handler
+	once             sync.Once       // make sure only signalShutdown once
+	cond             *sync.Cond      // shutdown signaler
+	shutdown         bool            // whether shutdown has been signaled, guarded by cond.L
+	stopCtx          context.Context // context of the shutdown request, guarded by cond.L
+	drainTimeout     time.Duration   // drain timeout of the shutdown request, guarded by cond.L
+	closing          chan struct{}   // closed once shutdown has been signaled
+	err              *ServerError    // cause of the shutdown if an event-loop exits, guarded by cond.L
+	codec            ICodec          // default codec for TCP stream
+	logger           Logger          // customized logger for logging info
+	connMu           sync.Mutex      // guards conns, connsPerIP and paused
+	conns            int             // number of the connections counted against MaxConnections
+	connsPerIP       map[string]int  // number of the connections counted against MaxConnectionsPerIP
+	paused           bool            // whether the listeners are paused by PauseAccepting
+	readLimit        *rateLimiter    // limiter of the bytes read from all the connections
+	writeLimit       *rateLimiter    // limiter of the bytes written to all the connections
+	signals          chan os.Signal  // signals relayed to the server, nil if it handles none
+	mainLoop         *eventloop      // main loop for accepting connections
+	eventHandler     EventHandler    // 时间处理回调
+	subLoopGroup     *eventLoopGroup // 循环处理事件
+	subLoopGroupSize int             // 子事件循环器大小
 }
 
 // waitForShutdown waits for a signal to shutdown
 func (svr *server) waitForShutdown() {
 	svr.cond.L.Lock()
-	svr.cond.Wait()
+	for !svr.shutdown {
+		svr.cond.Wait()
+	}
 	svr.cond.L.Unlock()
 }
 
 // signalShutdown signals a shutdown an begins server closing
 func (svr *server) signalShutdown() {
+	svr.signalShutdownContext(context.Background())
+}
+
+// signalLoopExit signals a shutdown caused by the exit of an event-loop, the cause is recorded
+// unless a shutdown has been signaled already.
+func (svr *server) signalLoopExit(idx int, err error) {
+	svr.cond.L.Lock()
+	if !svr.shutdown {
+		svr.err = &ServerError{Loop: idx, Err: err, Shutdown: err == ErrServerShutdown}
+	}
+	svr.cond.L.Unlock()
+	svr.signalShutdown()
+}
+
+// signalShutdownContext signals a shutdown which drains connections until ctx is done
+func (svr *server) signalShutdownContext(ctx context.Context) {
+	svr.signalShutdownDrain(ctx, svr.opts.DrainTimeout)
+}
+
+// signalShutdownDrain signals a shutdown which drains connections for drainTimeout at most until ctx is done,
+// zero means closing connections immediately.
+func (svr *server) signalShutdownDrain(ctx context.Context, drainTimeout time.Duration) {
 	svr.once.Do(func() {
 		svr.cond.L.Lock()
+		svr.shutdown = true
+		svr.stopCtx = ctx
+		svr.drainTimeout = drainTimeout
+		close(svr.closing)
 		svr.cond.Signal()
 		svr.cond.L.Unlock()
 	})
 }
 
-// startLoops .
+// startLoops runs the sub-loops unless they are shared with others, which are running already.
 func (svr *server) startLoops() {
-	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
-		svr.wg.Add(1)
-		go func() {
-			el.loopRun()
-			svr.wg.Done()
-		}()
+	if !svr.subLoopGroup.shared {
+		svr.subLoopGroup.start()
+	}
+}
+
+// closeLoops closes the pollers of the event-loops owned by the server.
+func (svr *server) closeLoops() {
+	if !svr.subLoopGroup.shared {
+		svr.subLoopGroup.closePollers()
+	}
+	if svr.mainLoop != nil {
+		_ = svr.mainLoop.poller.Close()
+	}
+}
+
+// detach closes the connections and removes the listeners of the server from the loops of the shared group,
+// which keep running for the other servers.
+func (svr *server) detach() {
+	atomic.StoreInt32(&svr.detached, 1)
+	g := svr.subLoopGroup
+	g.iterate(func(i int, el *eventloop) bool {
+		done := make(chan struct{})
+		if el.poller.Trigger(func() error {
+			el.detach(svr)
+			close(done)
+			return nil
+		}) == nil {
+			select {
+			case <-done:
+				return true
+			case <-el.done:
+			}
+		}
+		// The loop has exited, the server is detached from it here instead.
+		g.mu.Lock()
+		el.detach(svr)
+		g.mu.Unlock()
 		return true
 	})
 }
 
-// closeLoops .
-func (svr *server) closeLoops() {
+// startTicker schedules the Tick event on the first sub-loop.
+func (svr *server) startTicker() {
+	el := svr.subLoopGroup.eventLoops[0]
+	sniffError(el.poller.Trigger(func() error {
+		el.wheel.AfterFunc(0, func() error { return el.loopTick(svr) })
+		return nil
+	}))
+}
+
+// register registers the listener to an event-loop that is not running yet.
+func (svr *server) register(el *eventloop, ln *listener) (err error) {
+	if err = el.poller.AddRead(ln.fd); err == nil {
+		el.listeners[ln.fd] = ln
+	}
+	return
+}
+
+// loops returns the event-loops that poll the listener.
+func (svr *server) loops(ln *listener) (loops []*eventloop) {
+	if !ln.inLoops() {
+		return []*eventloop{svr.mainLoop}
+	}
+	if ln.pconn != nil && ln.sessionIdle > 0 {
+		// The datagrams of a peer are read by the loop keeping its session.
+		if ln.sessionLoop == nil {
+			ln.sessionLoop = svr.subLoopGroup.choose(ln.lnaddr)
+		}
+		return []*eventloop{ln.sessionLoop}
+	}
 	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
-		_ = el.poller.Close()
+		loops = append(loops, el)
 		return true
 	})
+	return
 }
 
-// startReactors .
-func (svr *server) startReactors() {
-	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+// isShutdown reports whether shutdown has been signaled.
+func (svr *server) isShutdown() bool {
+	svr.cond.L.Lock()
+	defer svr.cond.L.Unlock()
+	return svr.shutdown
+}
+
+// serverInfo returns a copy of the server information.
+func (svr *server) serverInfo() Server {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	info := svr.info
+	info.Addrs = append([]net.Addr(nil), svr.info.Addrs...)
+	return info
+}
+
+// addListener registers a listener to the running server, starting the main reactor if it is needed for the first time.
+func (svr *server) addListener(ln *listener) error {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if svr.isShutdown() {
+		return ErrServerShutdown
+	}
+	if ln.codec == nil {
+		ln.codec = svr.codec
+	}
+	ln.svr = svr
+
+	if !ln.inLoops() && svr.mainLoop == nil {
+		el, err := newEventLoop(-1, svr.logger)
+		if err != nil {
+			return err
+		}
+		if err = svr.register(el, ln); err != nil {
+			_ = el.poller.Close()
+			return err
+		}
+		svr.mainLoop = el
 		svr.wg.Add(1)
 		go func() {
-			svr.activateSubReactor(el)
+			svr.activateMainReactor()
 			svr.wg.Done()
 		}()
-		return true
-	})
+	} else if err := svr.registerRunning(ln); err != nil {
+		return err
+	}
+
+	svr.listeners = append(svr.listeners, ln)
+	svr.info.Addrs = append(svr.info.Addrs, ln.lnaddr)
+	return nil
 }
 
-// activateLoops .
-func (svr *server) activateLoops(numEventLoop int) error {
-	// 创建 loops 并绑定监听器
-	for i := 0; i < numEventLoop; i++ {
-		if p, err := netpoll.NewPoller(); err == nil {
-			el := &eventloop{
-				idx:          i,
-				svr:          svr,
-				codec:        svr.codec,
-				poller:       p,
-				packet:       make([]byte, 0x10000),
-				connections:  make(map[int]*conn),
-				eventHandler: svr.eventHandler,
+// registerRunning registers the listener to the running event-loops that poll it.
+func (svr *server) registerRunning(ln *listener) error {
+	var registered []*eventloop
+	for _, el := range svr.loops(ln) {
+		el := el
+		_ = el.poller.Trigger(func() error {
+			el.listeners[ln.fd] = ln
+			return nil
+		})
+		if err := el.poller.AddRead(ln.fd); err != nil {
+			for _, el := range append(registered, el) {
+				svr.unregister(el, ln, nil)
 			}
-			_ = el.poller.AddRead(svr.ln.fd)
-			svr.subLoopGroup.register(el)
-		} else {
 			return err
 		}
+		registered = append(registered, el)
 	}
-	svr.subLoopGroupSize = svr.subLoopGroup.len()
-	// 在后台运行loops
-	svr.startLoops()
 	return nil
 }
 
-// activateReactors .
-func (svr *server) activateReactors(numEventLoop int) error {
-	for i := 0; i < numEventLoop; i++ {
-		if p, err := netpoll.NewPoller(); err == nil {
-			el := &eventloop{
-				idx:          i,
-				svr:          svr,
-				codec:        svr.codec,
-				poller:       p,
-				packet:       make([]byte, 0x10000),
-				connections:  make(map[int]*conn),
-				eventHandler: svr.eventHandler,
+// unregister removes the listener from the running event-loop, done is invoked within the loop afterwards.
+func (svr *server) unregister(el *eventloop, ln *listener, done func()) {
+	_ = el.poller.Delete(ln.fd)
+	_ = el.poller.Trigger(func() error {
+		delete(el.listeners, ln.fd)
+		el.closeSessions(func(c *conn) bool { return c.ln == ln }, nil)
+		if done != nil {
+			done()
+		}
+		return nil
+	})
+}
+
+// removeListener unregisters the listener with the given address from the running server, the returned
+// listener's detached channel is closed once all the event-loops have let go of it.
+func (svr *server) removeListener(addr net.Addr) (*listener, error) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if svr.isShutdown() {
+		return nil, ErrServerShutdown
+	}
+	idx := -1
+	for i, ln := range svr.listeners {
+		if ln.lnaddr.Network() == addr.Network() && ln.lnaddr.String() == addr.String() {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, ErrListenerNotFound
+	}
+	ln := svr.listeners[idx]
+	svr.listeners = append(svr.listeners[:idx:idx], svr.listeners[idx+1:]...)
+	svr.info.Addrs = append(svr.info.Addrs[:idx:idx], svr.info.Addrs[idx+1:]...)
+
+	loops := svr.loops(ln)
+	pending := int32(len(loops))
+	for _, el := range loops {
+		svr.unregister(el, ln, func() {
+			if atomic.AddInt32(&pending, -1) == 0 {
+				close(ln.detached)
 			}
-			svr.subLoopGroup.register(el)
-		} else {
+		})
+	}
+	return ln, nil
+}
+
+// start creates the sub-loops, unless they are shared with others, and, if any listener needs it, the main reactor,
+// then runs them in the background.
+func (svr *server) start(numEventLoop int) error {
+	g := svr.subLoopGroup
+	for i := 0; i < numEventLoop && !g.shared; i++ {
+		el, err := newEventLoop(i, svr.logger)
+		if err != nil {
 			return err
 		}
+		g.register(el)
 	}
-	svr.subLoopGroupSize = svr.subLoopGroup.len()
-	// 开始子 reactors.
-	svr.startReactors()
+	svr.subLoopGroupSize = g.len()
 
-	if p, err := netpoll.NewPoller(); err == nil {
-		el := &eventloop{
-			idx:    -1,
-			poller: p,
-			svr:    svr,
+	for _, ln := range svr.listeners {
+		if !ln.inLoops() && svr.mainLoop == nil {
+			el, err := newEventLoop(-1, svr.logger)
+			if err != nil {
+				return err
+			}
+			svr.mainLoop = el
 		}
-		_ = el.poller.AddRead(svr.ln.fd)
-		svr.mainLoop = el
+		if ln.inLoops() && g.shared {
+			if err := svr.registerRunning(ln); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, el := range svr.loops(ln) {
+			if err := svr.register(el, ln); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 开始子 reactors.
+	svr.startLoops()
+	if svr.opts.Ticker {
+		svr.startTicker()
+	}
+
+	if svr.mainLoop != nil {
 		// 开始主 reactor.
 		svr.wg.Add(1)
 		go func() {
 			svr.activateMainReactor()
 			svr.wg.Done()
 		}()
-	} else {
-		return err
 	}
 	return nil
 }
 
-func (svr *server) start(numEventLoop int) error {
-	if svr.opts.ReusePort || svr.ln.pconn != nil {
-		return svr.activateLoops(numEventLoop)
+// stopAccepting removes the listeners from the event-loops and closes them once all the loops have let go of them,
+// so that no more connections are accepted.
+func (svr *server) stopAccepting() {
+	for _, ln := range svr.listeners {
+		ln := ln
+		loops := svr.loops(ln)
+		pending := int32(len(loops))
+		for _, el := range loops {
+			svr.unregister(el, ln, func() {
+				if atomic.AddInt32(&pending, -1) == 0 {
+					close(ln.detached)
+				}
+			})
+		}
+		awaitDetached(ln, loops)
+		ln.close()
+	}
+}
+
+// awaitDetached waits until the listener has been removed from the event-loops, the loops which have exited
+// never let go of it but poll it no more either.
+func awaitDetached(ln *listener, loops []*eventloop) {
+	for _, el := range loops {
+		select {
+		case <-ln.detached:
+			return
+		case <-el.done:
+		}
+	}
+}
+
+// closeListeners .
+func (svr *server) closeListeners() {
+	for _, ln := range svr.listeners {
+		ln.close()
+	}
+}
+
+// countConnections returns the number of connections of the server.
+func (svr *server) countConnections() int {
+	return int(atomic.LoadInt32(&svr.connCount))
+}
+
+// stats returns the statistics of the connections.
+func (svr *server) stats() Stats {
+	return Stats{
+		Connections: svr.countConnections(),
+		Accepted:    atomic.LoadUint64(&svr.accepted),
+		Filtered:    atomic.LoadUint64(&svr.filtered),
+		Rejected:    atomic.LoadUint64(&svr.rejected),
+	}
+}
+
+// liveConnections returns the number of connections of the server on the event-loops still running,
+// those left on the loops which have exited are not closed until the server is detached from them.
+func (svr *server) liveConnections() int {
+	n := svr.countConnections()
+	g := svr.subLoopGroup
+	g.iterate(func(i int, el *eventloop) bool {
+		select {
+		case <-el.done:
+		default:
+			return true
+		}
+		// The loop has exited, its connections are only touched by the servers detaching from it.
+		g.mu.Lock()
+		for _, c := range el.connections {
+			if c.svr == svr {
+				n--
+			}
+		}
+		for _, c := range el.sessions {
+			if c.svr == svr {
+				n--
+			}
+		}
+		g.mu.Unlock()
+		return true
+	})
+	return n
+}
+
+// drain stops accepting new connections and lets the event-loops go on serving the outstanding connections
+// until all of them have been closed, the drain timeout expires or the shutdown context is done.
+// The connections on the event-loops which have exited are not waited for.
+func (svr *server) drain() {
+	svr.stopAccepting()
+
+	timer := time.NewTimer(svr.drainTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for svr.liveConnections() > 0 {
+		select {
+		case <-timer.C:
+			return
+		case <-svr.stopCtx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	return svr.activateReactors(numEventLoop)
 }
 
 func (svr *server) stop() {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
 
-	// Notify all loops to close by closing all listeners
-	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
-		sniffError(el.poller.Trigger(func() error {
-			return ErrServerShutdown
-		}))
-		return true
-	})
+	// Wait for the listener changes in progress, none will be made from now on
+	svr.mu.Lock()
+	svr.mu.Unlock()
+
+	if svr.drainTimeout > 0 {
+		svr.drain()
+	}
+
+	// Notify all loops to close by closing all listeners, the loops of a shared group keep running for the others
+	if !svr.subLoopGroup.shared {
+		svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+			sniffError(el.poller.Trigger(func() error {
+				return ErrServerShutdown
+			}))
+			return true
+		})
+	}
 
 	if svr.mainLoop != nil {
-		svr.ln.close()
+		svr.closeListeners()
 		sniffError(svr.mainLoop.poller.Trigger(func() error {
 			return ErrServerShutdown
 		}))
@@ -171,36 +486,90 @@ func (svr *server) stop() {
 	svr.wg.Wait()
 
 	// Close loops and all outstanding connections
-	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
-		for _, c := range el.connections {
-			sniffError(el.loopCloseConn(c, nil))
-		}
-		return true
-	})
+	if svr.subLoopGroup.shared {
+		svr.detach()
+	} else {
+		svr.subLoopGroup.wg.Wait()
+		svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+			el.detach(svr)
+			return true
+		})
+	}
 	svr.closeLoops()
+	svr.closeListeners()
 
-	if svr.mainLoop != nil {
-		sniffError(svr.mainLoop.poller.Close())
-	}
+	svr.eventHandler.OnShutdown(svr.info)
+	svr.subLoopGroup.detach(svr)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options) error {
-	// Figure out the correct number of loops/goroutines to use.
-	numEventLoop := 1
-	if options.Multicore {
-		numEventLoop = runtime.NumCPU()
+// startPreforkMaster starts the prefork worker processes and returns a handle to control them.
+func startPreforkMaster(addr string, options *Options) (*ServerHandle, error) {
+	addrs := []string{addr}
+	for _, lc := range options.Listeners {
+		addrs = append(addrs, lc.Addr)
+	}
+	for _, a := range addrs {
+		if network, _ := parseAddr(a); network == "unix" {
+			return nil, ErrProtocolNotSupported
+		}
+	}
+	p, err := startPrefork(options.Prefork, func() Logger {
+		if options.Logger == nil {
+			return defaultLogger
+		}
+		return options.Logger
+	}(), options.SignalHandling)
+	if err != nil {
+		return nil, err
 	}
-	if options.NumEventLoop > 0 {
-		numEventLoop = options.NumEventLoop
+	return &ServerHandle{prefork: p, done: p.done}, nil
+}
+
+// newServerHandle runs the shutdown sequence of svr in the background and returns a handle to control it,
+// a nil svr means the server has been shut down during initialization.
+func newServerHandle(svr *server) *ServerHandle {
+	h := &ServerHandle{svr: svr, done: make(chan struct{})}
+	if svr == nil {
+		close(h.done)
+		return h
 	}
+	go func() {
+		svr.stop()
+		if svr.err != nil {
+			h.cause = svr.err
+			if !svr.err.Shutdown {
+				h.err = svr.err
+			}
+		}
+		close(h.done)
+	}()
+	return h
+}
+
+func serve(eventHandler EventHandler, listeners []*listener, options *Options) (*server, error) {
+	// Figure out the correct number of loops/goroutines to use.
+	numEventLoop := numEventLoops(options)
 
 	svr := new(server)
 	svr.opts = options
 	svr.eventHandler = eventHandler
-	svr.ln = listener
-	svr.subLoopGroup = new(eventLoopGroup)
+	svr.listeners = listeners
+	if options.EventLoopGroup != nil {
+		svr.subLoopGroup = options.EventLoopGroup.g
+		numEventLoop = svr.subLoopGroup.len()
+	} else {
+		svr.subLoopGroup = newEventLoopGroup(func() LoadBalancer {
+			if options.LoadBalancer == nil {
+				return newLoadBalancer(options.LB)
+			}
+			return options.LoadBalancer
+		}())
+	}
 	svr.cond = sync.NewCond(&sync.Mutex{})
-	svr.ticktock = make(chan time.Duration, 1)
+	svr.closing = make(chan struct{})
+	svr.connsPerIP = make(map[string]int)
+	svr.readLimit = newRateLimiter(options.ReadRateLimit)
+	svr.writeLimit = newRateLimiter(options.WriteRateLimit)
 	svr.logger = func() Logger {
 		if options.Logger == nil {
 			return defaultLogger
@@ -214,25 +583,50 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 		return options.Codec
 	}()
 
-	server := Server{
+	addrs := make([]net.Addr, len(listeners))
+	for i, ln := range listeners {
+		if ln.codec == nil {
+			ln.codec = svr.codec
+		}
+		ln.svr = svr
+		addrs[i] = ln.lnaddr
+	}
+	svr.info = Server{
 		Multicore:    options.Multicore,
-		Addr:         listener.lnaddr,
+		Addrs:        addrs,
 		NumEventLoop: numEventLoop,
 		ReusePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
+		Prefork:      options.Prefork > 0,
+	}
+	if len(addrs) > 0 {
+		svr.info.Addr = addrs[0]
 	}
-	switch svr.eventHandler.OnInitComplete(server) {
+	if svr.info.Prefork {
+		svr.info.WorkerIndex = preforkWorkerIndex()
+	}
+	switch svr.eventHandler.OnInitComplete(svr.info) {
 	case None:
 	case Shutdown:
-		return nil
+		return nil, nil
 	}
 
+	if err := svr.subLoopGroup.attach(svr); err != nil {
+		return nil, err
+	}
 	if err := svr.start(numEventLoop); err != nil {
+		if svr.subLoopGroup.shared {
+			svr.detach()
+		}
+		svr.subLoopGroup.detach(svr)
 		svr.closeLoops()
 		svr.logger.Printf("netti server is stoping with error: %v\n", err)
-		return err
+		return nil, err
+	}
+	if options.HotRestartSignal != nil || options.SignalHandling {
+		svr.signals = svr.notifySignals()
+		go svr.watchSignals()
 	}
-	defer svr.stop()
 
-	return nil
+	return svr, nil
 }
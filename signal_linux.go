@@ -0,0 +1,92 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals starts relaying the signals set up by the options to the returned channel.
+func (svr *server) notifySignals() chan os.Signal {
+	var sigs []os.Signal
+	if svr.opts.SignalHandling {
+		sigs = append(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1)
+	}
+	if svr.opts.HotRestartSignal != nil {
+		sigs = append(sigs, svr.opts.HotRestartSignal)
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	return ch
+}
+
+// watchSignals handles the signals relayed to svr.signals until the server begins to shut down.
+func (svr *server) watchSignals() {
+	defer signal.Stop(svr.signals)
+	for {
+		select {
+		case sig := <-svr.signals:
+			svr.handleSignal(sig)
+		case <-svr.closing:
+			return
+		}
+	}
+}
+
+// handleSignal .
+func (svr *server) handleSignal(sig os.Signal) {
+	if sig == svr.opts.HotRestartSignal {
+		if err := svr.hotRestart(); err != nil {
+			svr.logger.Printf("failed to hot restart with error:%v\n", err)
+		}
+		return
+	}
+	switch sig {
+	case syscall.SIGTERM, syscall.SIGINT:
+		// The shutdown begins within an event-loop as the other signals are handled, unless the loop is gone.
+		shutdown := func() error {
+			svr.logger.Printf("netti server is shutting down on signal:%v\n", sig)
+			drainTimeout := svr.opts.DrainTimeout
+			if drainTimeout <= 0 {
+				drainTimeout = signalDrainTimeout
+			}
+			svr.signalShutdownDrain(context.Background(), drainTimeout)
+			return nil
+		}
+		if err := svr.subLoopGroup.eventLoops[0].poller.Trigger(shutdown); err != nil {
+			_ = shutdown()
+		}
+	case syscall.SIGHUP:
+		if svr.opts.OnReload == nil {
+			return
+		}
+		svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+			sniffError(el.poller.Trigger(func() error {
+				if err := svr.opts.OnReload(el); err != nil {
+					svr.logger.Printf("event-loop:%d failed to reload with error:%v\n", el.idx, err)
+				}
+				return nil
+			}))
+			return true
+		})
+	case syscall.SIGUSR1:
+		dump := func(el *eventloop) {
+			sniffError(el.poller.Trigger(func() error {
+				el.dumpState()
+				return nil
+			}))
+		}
+		svr.mu.Lock()
+		if svr.mainLoop != nil {
+			dump(svr.mainLoop)
+		}
+		svr.mu.Unlock()
+		svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+			dump(el)
+			return true
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// +build linux
+
+package netti
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	lines chan string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	select {
+	case l.lines <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+func (l *testLogger) Debugf(template string, args ...interface{})  { l.Printf(template, args...) }
+func (l *testLogger) Infof(template string, args ...interface{})   { l.Printf(template, args...) }
+func (l *testLogger) Warnf(template string, args ...interface{})   { l.Printf(template, args...) }
+func (l *testLogger) Errorf(template string, args ...interface{})  { l.Printf(template, args...) }
+func (l *testLogger) DPanicf(template string, args ...interface{}) { l.Printf(template, args...) }
+func (l *testLogger) Panicf(template string, args ...interface{})  { l.Printf(template, args...) }
+func (l *testLogger) Fatalf(template string, args ...interface{})  { l.Printf(template, args...) }
+
+func TestSignalHandling(t *testing.T) {
+	logger := &testLogger{lines: make(chan string, 16)}
+	reloaded := make(chan int, 2)
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithNumEventLoop(2), WithLogger(logger),
+		WithSignalHandling(func(loop EventLoop) error {
+			reloaded <- loop.Index()
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	h.svr.signals <- syscall.SIGHUP
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reloaded:
+		case <-time.After(time.Second):
+			t.Fatal("reload was not invoked within every event-loop")
+		}
+	}
+
+	h.svr.signals <- syscall.SIGUSR1
+	for dumped := 0; dumped < 2; {
+		select {
+		case line := <-logger.lines:
+			if strings.HasPrefix(line, "event-loop:") && strings.Contains(line, "connections:") {
+				dumped++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("state of event-loops was not dumped")
+		}
+	}
+
+	h.svr.signals <- syscall.SIGTERM
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		t.Fatal("server was not shut down on SIGTERM")
+	}
+}
+
+func TestSignalShutdownDrain(t *testing.T) {
+	es := &testEchoServer{EventServer: new(EventServer), shutdown: make(chan Server, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithSignalHandling(nil))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr := h.Server().Addr.String()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	waitFor(t, "the connection to be opened", func() bool { return h.Stats().Connections == 1 })
+
+	// Without a drain timeout set up, SIGTERM still lets the connection in flight finish.
+	h.svr.signals <- syscall.SIGTERM
+	waitFor(t, "the listener to be closed", func() bool {
+		cc, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return true
+		}
+		_ = cc.Close()
+		return false
+	})
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write while draining: %v", err)
+	}
+	if _, err = c.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("failed to read while draining: %q, %v", buf, err)
+	}
+	_ = c.Close()
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		t.Fatal("server was not shut down after the connection in flight finished")
+	}
+}
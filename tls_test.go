@@ -0,0 +1,433 @@
+// +build linux
+
+package netti
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates of the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "netti test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type testTLSServer struct {
+	*EventServer
+	states chan *tls.ConnectionState
+}
+
+func (es *testTLSServer) OnOpened(c Conn) (out []byte, action Action) {
+	es.states <- c.TLSConnectionState()
+	return
+}
+
+func (es *testTLSServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = append([]byte(nil), frame...)
+	return
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certs := map[string]tls.Certificate{
+		"a.test": ca.issue(t, "a.test"),
+		"b.test": ca.issue(t, "b.test"),
+	}
+	serverConfig := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, ok := certs[hello.ServerName]
+			if !ok {
+				cert = certs["a.test"]
+			}
+			return &cert, nil
+		},
+		NextProtos: []string{"echo", "h2"},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  ca.pool,
+	}
+	es := &testTLSServer{EventServer: new(EventServer), states: make(chan *tls.ConnectionState, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithTLSConfig(serverConfig),
+		WithListener("tcp://127.0.0.1:0", WithTLSConfig(nil)))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addrs := h.Server().Addrs
+
+	// The certificate is selected by SNI and the protocol is negotiated by ALPN.
+	clientCert := ca.issue(t, "client")
+	c, err := tls.Dial("tcp", addrs[0].String(), &tls.Config{
+		ServerName:   "b.test",
+		RootCAs:      ca.pool,
+		NextProtos:   []string{"h2"},
+		Certificates: []tls.Certificate{clientCert},
+	})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	state := <-es.states
+	if state == nil || state.ServerName != "b.test" || state.NegotiatedProtocol != "h2" {
+		t.Fatalf("unexpected state of the tls connection: %+v", state)
+	}
+	if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "client" {
+		t.Fatal("the client certificate should be exposed")
+	}
+	if cs := c.ConnectionState(); cs.PeerCertificates[0].Subject.CommonName != "b.test" {
+		t.Fatalf("unexpected server certificate: %s", cs.PeerCertificates[0].Subject.CommonName)
+	}
+
+	// The data spanning many records is echoed in plaintext.
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	go func() { _, _ = c.Write(msg) }()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echo := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, echo); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(echo, msg) {
+		t.Fatal("the echo mismatches")
+	}
+
+	// The extra listener is a plaintext one.
+	testEcho(t, "tcp", addrs[1].String(), "hello plaintext")
+	if state = <-es.states; state != nil {
+		t.Fatal("a plaintext connection should have no tls state")
+	}
+
+	// The dialed connections verify the server against the host of the address.
+	ch := &testClientHandler{EventServer: new(EventServer), opened: make(chan Conn, 1), frames: make(chan string, 1)}
+	cli, err := NewClient(ch, WithTLSConfig(&tls.Config{RootCAs: ca.pool, NextProtos: []string{"echo"}}))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer cli.Stop(context.Background())
+	cc, err := cli.Dial("tcp://" + addrs[0].String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	<-ch.opened
+	<-es.states
+	if state := cc.TLSConnectionState(); state == nil || state.NegotiatedProtocol != "echo" {
+		t.Fatalf("unexpected state of the dialed tls connection: %+v", state)
+	}
+	_ = cc.AsyncWrite([]byte("hello tls"))
+	select {
+	case frame := <-ch.frames:
+		if frame != "hello tls" {
+			t.Fatalf("unexpected echo: %q", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the echo")
+	}
+
+	untrusted, err := NewClient(new(EventServer), WithTLSConfig(&tls.Config{ServerName: "a.test"}))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer untrusted.Stop(context.Background())
+	if _, err = untrusted.Dial("tcp://" + addrs[0].String()); err == nil {
+		t.Fatal("dialing a server with an untrusted certificate should fail")
+	}
+
+	// The host is sent by SNI even if the server is not verified.
+	insecure, err := NewClient(new(EventServer), WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer insecure.Stop(context.Background())
+	_, port, _ := net.SplitHostPort(addrs[0].String())
+	if _, err = insecure.Dial("tcp4://localhost:" + port); err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if state = <-es.states; state == nil || state.ServerName != "localhost" {
+		t.Fatal("the host should be sent by SNI")
+	}
+}
+
+// recordConn records the ciphertext read from the connection.
+type recordConn struct {
+	net.Conn
+	in []byte
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in = append(c.in, b[:n]...)
+	return n, err
+}
+
+// lastRecordType returns the type of the last complete record read.
+func (c *recordConn) lastRecordType() (typ byte) {
+	for in := c.in; len(in) >= 5; {
+		n := 5 + int(in[3])<<8 + int(in[4])
+		if len(in) < n {
+			break
+		}
+		typ, in = in[0], in[n:]
+	}
+	return
+}
+
+func TestTLSCloseNotify(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "a.test")
+	es := &testTLSServer{EventServer: new(EventServer), states: make(chan *tls.ConnectionState, 1)}
+	// The alerts of TLS 1.2 are told apart from the data by their record type.
+	h, err := Start(es, "tcp://127.0.0.1:0",
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	raw, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	rc := &recordConn{Conn: raw}
+	c := tls.Client(rc, &tls.Config{ServerName: "a.test", RootCAs: ca.pool})
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err = c.Handshake(); err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	<-es.states
+
+	// The connections closed by the server end with close_notify.
+	if err = h.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error reading the closed connection: %v", err)
+	}
+	if typ := rc.lastRecordType(); typ != 21 {
+		t.Fatalf("the last record should be an alert, got type: %d", typ)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	es := &testTLSServer{EventServer: new(EventServer), states: make(chan *tls.ConnectionState, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithTLSConfig(&tls.Config{}),
+		WithTLSHandshakeTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	c, err := net.Dial("tcp", h.Server().Addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("the connection should be closed without handshake, got: %v", err)
+	}
+	waitFor(t, "the connection to be uncounted", func() bool { return h.Stats().Connections == 0 })
+}
+
+// splitConn writes the data in two halves apart, so that a record arrives in pieces.
+type splitConn struct {
+	net.Conn
+}
+
+func (c splitConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	time.Sleep(20 * time.Millisecond)
+	m, err := c.Conn.Write(b[len(b)/2:])
+	return n + m, err
+}
+
+func TestTLSHandshakeDeferred(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "a.test")
+	es := &testTLSServer{EventServer: new(EventServer), states: make(chan *tls.ConnectionState, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr := h.Server().Addr.String()
+
+	// The connections sending nothing do not start the handshake goroutines.
+	base := runtime.NumGoroutine()
+	const idle = 32
+	for i := 0; i < idle; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer c.Close()
+	}
+	waitFor(t, "the idle connections to be accepted", func() bool { return h.Stats().Connections == idle })
+	if n := runtime.NumGoroutine() - base; n >= idle {
+		t.Fatalf("%d goroutines started for %d idle connections", n, idle)
+	}
+
+	// The handshake starts once the first record has arrived in pieces.
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	c := tls.Client(splitConn{raw}, &tls.Config{ServerName: "a.test", RootCAs: ca.pool})
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err = c.Handshake(); err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if state := <-es.states; state == nil {
+		t.Fatal("the connection should be opened with the tls state")
+	}
+}
+
+// helloConn takes the first flight of a TLS client and fails it.
+type helloConn struct {
+	net.Conn
+	hello []byte
+}
+
+func (c *helloConn) Write(b []byte) (int, error) {
+	if c.hello == nil {
+		c.hello = append([]byte(nil), b...)
+	}
+	return 0, io.ErrClosedPipe
+}
+
+func TestTLSHandshakeBound(t *testing.T) {
+	max := maxLoopTLSHandshakes
+	maxLoopTLSHandshakes = 2
+	defer func() { maxLoopTLSHandshakes = max }()
+
+	ca := newTestCA(t)
+	cert := ca.issue(t, "a.test")
+	es := &testTLSServer{EventServer: new(EventServer), states: make(chan *tls.ConnectionState, 1)}
+	h, err := Start(es, "tcp://127.0.0.1:0", WithNumEventLoop(1),
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr := h.Server().Addr.String()
+	el := h.svr.subLoopGroup.eventLoops[0]
+	handshakes := func() (running, queued int) {
+		done := make(chan struct{})
+		_ = el.poller.Trigger(func() error {
+			running, queued = el.handshakes, len(el.handshaking)
+			close(done)
+			return nil
+		})
+		<-done
+		return
+	}
+
+	// The handshakes of the clients stuck after the first flight are run by no more goroutines than the bound.
+	hc := &helloConn{}
+	_ = tls.Client(hc, &tls.Config{ServerName: "a.test"}).Handshake()
+	const stuck = 6
+	var conns []net.Conn
+	for i := 0; i < stuck; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer c.Close()
+		if _, err = c.Write(hc.hello); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		conns = append(conns, c)
+	}
+	waitFor(t, "the handshakes to be requested", func() bool {
+		running, queued := handshakes()
+		return running+queued == stuck
+	})
+	if running, _ := handshakes(); running != maxLoopTLSHandshakes {
+		t.Fatalf("%d handshake goroutines are running, want: %d", running, maxLoopTLSHandshakes)
+	}
+
+	// The queued handshake runs once the running ones have been given up.
+	result := make(chan error, 1)
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	c := tls.Client(raw, &tls.Config{ServerName: "a.test", RootCAs: ca.pool})
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	go func() { result <- c.Handshake() }()
+	waitFor(t, "the handshake to be queued", func() bool {
+		_, queued := handshakes()
+		return queued == stuck-maxLoopTLSHandshakes+1
+	})
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	if err = <-result; err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	<-es.states
+	waitFor(t, "the handshake goroutines to return", func() bool {
+		running, queued := handshakes()
+		return running == 0 && queued == 0
+	})
+}
@@ -0,0 +1,392 @@
+// +build linux
+
+package netti
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"netti/internal/timingwheel"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultTLSHandshakeTimeout bounds the TLS handshake if Options.TLSHandshakeTimeout is not set.
+const defaultTLSHandshakeTimeout = 10 * time.Second
+
+// maxLoopTLSHandshakes bounds the TLS handshake goroutines running for the connections of an event-loop at once,
+// the handshakes of the other connections wait for them to complete.
+var maxLoopTLSHandshakes = 128
+
+const (
+	tlsRecordHeaderLen = 5           // type, version and length
+	tlsMaxRecordLen    = 1<<14 + 256 // the longest record allowed by TLS 1.3, ciphertext included
+)
+
+// errWouldBlock is returned by the transport of a TLS session when there is no ciphertext to read, it is temporary
+// so that crypto/tls keeps the partial record and resumes on the next read.
+var errWouldBlock net.Error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "no data available for the tls connection" }
+func (wouldBlockError) Timeout() bool   { return false }
+func (wouldBlockError) Temporary() bool { return true }
+
+// tlsTransport is the net.Conn under the tls.Conn of a connection, it carries the ciphertext between the loop and
+// crypto/tls: the loop feeds in what it reads from the socket and takes out what crypto/tls writes.
+// Read blocks during the handshake, which is run by a goroutine, and returns errWouldBlock afterwards,
+// as the records are only decrypted within the loop. The handshake can not be run by the loop likewise,
+// crypto/tls keeps the error of an interrupted handshake and never resumes it.
+type tlsTransport struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	in       []byte             // ciphertext read from the socket
+	out      []byte             // ciphertext to write to the socket
+	blocking bool               // whether Read waits for the ciphertext
+	closed   bool               // whether the connection has been closed
+	flush    func()             // invoked when the handshake goroutine writes the ciphertext
+	laddr    net.Addr           // local address of the connection
+	raddr    net.Addr           // remote address of the connection
+	timer    *timingwheel.Timer // timeout of the handshake
+}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.in) == 0 {
+		if t.closed {
+			return 0, io.EOF
+		}
+		if !t.blocking {
+			return 0, errWouldBlock
+		}
+		t.cond.Wait()
+	}
+	n := copy(b, t.in)
+	t.in = t.in[:copy(t.in, t.in[n:])]
+	return n, nil
+}
+
+func (t *tlsTransport) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	flush := t.blocking && len(t.out) == 0
+	t.out = append(t.out, b...)
+	t.mu.Unlock()
+	if flush {
+		t.flush()
+	}
+	return len(b), nil
+}
+
+func (t *tlsTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr                { return t.laddr }
+func (t *tlsTransport) RemoteAddr() net.Addr               { return t.raddr }
+func (t *tlsTransport) SetDeadline(_ time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(_ time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(_ time.Time) error { return nil }
+
+// feed appends the ciphertext read from the socket.
+func (t *tlsTransport) feed(b []byte) {
+	t.mu.Lock()
+	t.in = append(t.in, b...)
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// buffered reports whether there is ciphertext not read by crypto/tls yet.
+func (t *tlsTransport) buffered() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.in) > 0
+}
+
+// recordBuffered reports whether the first record from the peer has been fed completely, or whether its header
+// is enough for crypto/tls to reject it.
+func (t *tlsTransport) recordBuffered() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.in) < tlsRecordHeaderLen {
+		return false
+	}
+	n := int(binary.BigEndian.Uint16(t.in[3:tlsRecordHeaderLen]))
+	return n > tlsMaxRecordLen || len(t.in) >= tlsRecordHeaderLen+n
+}
+
+// take returns the ciphertext written by crypto/tls so far.
+func (t *tlsTransport) take() (out []byte) {
+	t.mu.Lock()
+	out, t.out = t.out, nil
+	t.mu.Unlock()
+	return
+}
+
+// handshaked stops blocking the reads once the handshake has completed.
+func (t *tlsTransport) handshaked() {
+	t.mu.Lock()
+	t.blocking = false
+	t.mu.Unlock()
+}
+
+// tlsSession is the TLS state of a connection.
+type tlsSession struct {
+	*tls.Conn
+	t       *tlsTransport
+	dialer  *dialer              // dial waiting for the handshake of an outbound connection
+	state   *tls.ConnectionState // state of the connection once the handshake has completed
+	started bool                 // whether the handshake goroutine has been started or queued
+}
+
+// tlsConfig returns the TLS config of the connection, nil if it is a plaintext one.
+func (c *conn) tlsConfig() *tls.Config {
+	if c.ln != nil {
+		return c.ln.tlsConfig
+	}
+	return c.svr.opts.TLSConfig
+}
+
+// loopHandshake sets up the TLS handshake of a connection polled by the loop, the handshake is run by a goroutine
+// with the ciphertext carried by the loop, the connection is opened once the handshake has completed.
+// The goroutine of an accepted connection is not started until the first record from the peer has been read,
+// so that the connections which send nothing cost no more than a timer until the handshake timeout.
+// The goroutine returns as soon as the connection is closed, by the handshake timeout or otherwise.
+// d is the dial of an outbound connection which is completed along with the handshake, nil for an accepted one.
+func (el *eventloop) loopHandshake(c *conn, config *tls.Config, d *dialer) error {
+	t := &tlsTransport{blocking: true, laddr: c.localAddr, raddr: c.remoteAddr}
+	t.cond = sync.NewCond(&t.mu)
+	t.flush = func() {
+		_ = el.poller.Trigger(func() error {
+			if out := t.take(); len(out) > 0 && el.connections[c.fd] == c {
+				c.writeRaw(out)
+			}
+			return nil
+		})
+	}
+	s := &tlsSession{t: t, dialer: d}
+	if d != nil {
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = d.host
+		}
+		s.Conn = tls.Client(t, config)
+	} else {
+		s.Conn = tls.Server(t, config)
+	}
+	c.tls = s
+
+	timeout := c.svr.opts.TLSHandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultTLSHandshakeTimeout
+	}
+	t.timer = timingwheel.NewTimer(timeout, false, func() error {
+		delete(c.timers, t.timer)
+		if el.connections[c.fd] == c && !c.opened {
+			return el.loopCloseConn(c, ErrHandshakeTimeout)
+		}
+		return nil
+	})
+	c.addTimer(t.timer)
+
+	if d != nil {
+		// The client speaks first.
+		el.startHandshake(c, s)
+	}
+	return nil
+}
+
+// startHandshake starts the goroutine running the handshake of the session, or queues the connection until one of
+// the running handshakes completes if there are maxLoopTLSHandshakes of them already.
+func (el *eventloop) startHandshake(c *conn, s *tlsSession) {
+	s.started = true
+	if el.handshakes >= maxLoopTLSHandshakes {
+		el.handshaking = append(el.handshaking, c)
+		return
+	}
+	el.runHandshake(c, s)
+}
+
+// runHandshake runs the handshake of the session by a goroutine, which is counted until the handshake completes.
+func (el *eventloop) runHandshake(c *conn, s *tlsSession) {
+	el.handshakes++
+	go func() {
+		err := s.Handshake()
+		_ = el.poller.Trigger(func() error {
+			el.handshakes--
+			el.nextHandshake()
+			return el.loopHandshaked(c, s, err)
+		})
+	}()
+}
+
+// nextHandshake runs the handshakes of the queued connections which have not been closed meanwhile,
+// as far as maxLoopTLSHandshakes allows.
+func (el *eventloop) nextHandshake() {
+	for len(el.handshaking) > 0 && el.handshakes < maxLoopTLSHandshakes {
+		c := el.handshaking[0]
+		el.handshaking[0] = nil
+		el.handshaking = el.handshaking[1:]
+		if el.connections[c.fd] == c && c.tls != nil && !c.opened {
+			el.runHandshake(c, c.tls)
+		}
+	}
+}
+
+// loopHandshaked opens the connection whose TLS handshake has completed, or closes it with the handshake error.
+func (el *eventloop) loopHandshaked(c *conn, s *tlsSession, err error) error {
+	if el.connections[c.fd] != c || c.tls != s {
+		return nil // closed meanwhile
+	}
+	if err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	s.t.handshaked()
+	c.removeTimer(s.t.timer)
+	state := s.ConnectionState()
+	s.state = &state
+	if out := s.t.take(); len(out) > 0 {
+		if c.writeRaw(out); el.connections[c.fd] != c {
+			return nil // failed to write
+		}
+	}
+	if d := s.dialer; d != nil {
+		s.dialer = nil
+		if d.attach != nil {
+			d.attach(c)
+		}
+		d.done <- nil
+	}
+	if err = el.loopOpen(c); err != nil || !c.opened {
+		return err
+	}
+	if s.t.buffered() {
+		// The records sent by the peer right after the handshake.
+		return el.loopReadTLS(c, nil)
+	}
+	return nil
+}
+
+// loopHandshakeEOF stops reading the connection closed by the peer during the TLS handshake, so that the handshake
+// fails with the alert sent by the peer, if any, rather than the EOF, the connection is closed along with it.
+func (el *eventloop) loopHandshakeEOF(c *conn) error {
+	s := c.tls
+	_ = s.t.Close()
+	if !s.started {
+		el.startHandshake(c, s)
+	}
+	c.readPaused = true
+	return el.updateEvents(c)
+}
+
+// loopReadTLS feeds the ciphertext read from a TLS connection to its session, it is consumed by the handshake
+// goroutine until the connection is opened, the plaintext decrypted from it is reacted on afterwards.
+func (el *eventloop) loopReadTLS(c *conn, data []byte) error {
+	s := c.tls
+	s.t.feed(data)
+	if !c.opened {
+		if !s.started && s.t.recordBuffered() {
+			el.startHandshake(c, s)
+		}
+		return nil
+	}
+	if el.plaintext == nil {
+		el.plaintext = make([]byte, 0, len(el.packet))
+	}
+	buf, err := s.unseal(el.plaintext)
+	el.plaintext = buf[:0]
+	// The responses to the post-handshake messages, e.g. KeyUpdate.
+	if out := s.t.take(); len(out) > 0 {
+		if c.writeRaw(out); !c.opened {
+			return nil
+		}
+	}
+	if len(buf) > 0 {
+		c.buffer = buf
+		if err := el.loopReact(c); err != nil || !c.opened {
+			return err
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = nil // close_notify
+		}
+		return el.loopCloseConn(c, err)
+	}
+	return nil
+}
+
+// unseal decrypts the records fed so far into buf, it returns io.EOF once the peer has sent close_notify.
+func (s *tlsSession) unseal(buf []byte) ([]byte, error) {
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := s.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			if errors.Is(err, errWouldBlock) {
+				err = nil
+			}
+			return buf, err
+		}
+	}
+}
+
+// seal encrypts the plaintext into records, it fails once the session has been closed or broken by a failed write.
+func (s *tlsSession) seal(b []byte) ([]byte, error) {
+	if _, err := s.Write(b); err != nil {
+		return nil, err
+	}
+	return s.t.take(), nil
+}
+
+// close closes the transport of the session of a closed connection, failing the dial waiting for the handshake.
+func (s *tlsSession) close(err error) {
+	_ = s.t.Close()
+	if d := s.dialer; d != nil {
+		s.dialer = nil
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		d.op.Err = err
+		d.done <- d.op
+	}
+}
+
+// closeNotify writes a close_notify alert to the socket of the connection being closed, as far as the socket takes
+// it without blocking, once the handshake has completed.
+func (s *tlsSession) closeNotify(fd int) {
+	if s.state == nil {
+		return
+	}
+	if err := s.CloseWrite(); err != nil {
+		return
+	}
+	if out := s.t.take(); len(out) > 0 {
+		_, _ = unix.Write(fd, out)
+	}
+}
+
+// TLSConnectionState returns a copy of the state of the TLS connection once its handshake has completed,
+// nil for a plaintext connection or a TLS one which has not been opened yet.
+func (c *conn) TLSConnectionState() *tls.ConnectionState {
+	if c.tls == nil || c.tls.state == nil {
+		return nil
+	}
+	state := *c.tls.state
+	return &state
+}
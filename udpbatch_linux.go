@@ -0,0 +1,156 @@
+// +build linux
+
+package netti
+
+import (
+	"netti/internal/netpoll"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultUDPBatchSize is the number of datagrams read and written in one system call if Options.UDPBatchSize is not set.
+const defaultUDPBatchSize = 32
+
+// maxUDPBatches bounds the batches read on one wake-up, so that a flood of datagrams does not starve the other
+// events of the loop, the rest is read on the next wake-up.
+const maxUDPBatches = 16
+
+// datagramBatch holds the datagrams read by one recvmmsg and the replies to them, which are written by sendmmsg
+// once all the datagrams of the batch have been reacted on.
+type datagramBatch struct {
+	msgs    *netpoll.MsgBatch
+	control bool            // whether the control messages are read, for the receive offload
+	bufs    [][]byte        // buffers of the datagrams to read
+	lens    []int           // lengths of the datagrams read
+	addrs   []unix.Sockaddr // sources of the datagrams read
+	fd      int             // socket the replies are written to, -1 if the replies are not being collected
+	out     []byte          // replies collected, back to back
+	outEnds []int           // ends of the replies in out
+	outTo   []unix.Sockaddr // destinations of the replies
+	iov     [][]byte        // replies being written
+}
+
+// datagramBatch returns the batch of the loop for the datagrams of the listener, nil if they are read one at a time.
+// The batches are kept by size, so that the servers sharing the loop with different sizes do not replace each other's.
+// The control messages are read into a batch only once it is used for a listener with the receive offload.
+func (el *eventloop) datagramBatch(ln *listener) *datagramBatch {
+	size := ln.svr.opts.UDPBatchSize
+	if size <= 0 {
+		size = defaultUDPBatchSize
+	}
+	if size == 1 || el.noMmsg {
+		return nil
+	}
+	b, ok := el.batches[size]
+	if !ok {
+		b = el.newDatagramBatch(size)
+	}
+	if ln.gro && !b.control {
+		b.msgs.EnableControl(netpoll.GROControlSize)
+		b.control = true
+	}
+	return b
+}
+
+// newDatagramBatch creates a batch of size datagrams and keeps it for the loop.
+func (el *eventloop) newDatagramBatch(size int) *datagramBatch {
+	b := &datagramBatch{
+		msgs:  netpoll.NewMsgBatch(size),
+		bufs:  make([][]byte, size),
+		lens:  make([]int, size),
+		addrs: make([]unix.Sockaddr, size),
+		fd:    -1,
+	}
+	mem := make([]byte, size*len(el.packet))
+	for i := range b.bufs {
+		b.bufs[i] = mem[i*len(el.packet) : (i+1)*len(el.packet)]
+	}
+	if el.batches == nil {
+		el.batches = make(map[int]*datagramBatch)
+	}
+	el.batches[size] = b
+	return b
+}
+
+// loopReadUDPBatch drains the listener in batches of datagrams, the replies to a batch are written together
+// before the next batch is read. It falls back to reading one datagram at a time if recvmmsg is not supported.
+func (el *eventloop) loopReadUDPBatch(ln *listener, b *datagramBatch) error {
+	for round := 0; round < maxUDPBatches; round++ {
+		n, err := b.msgs.Recvmmsg(ln.fd, b.bufs, b.lens, b.addrs)
+		if err != nil {
+			if err == unix.ENOSYS {
+				el.noMmsg = true
+				return el.loopReadUDP(ln)
+			}
+			if err != unix.EAGAIN && err != unix.EINTR {
+				el.logger.Printf("failed to read UPD packets from fd:%d, error:%v\n", ln.fd, err)
+			}
+			return nil
+		}
+		b.fd = ln.fd
+		el.batch = b
+		for i := 0; i < n && err == nil; i++ {
+			if b.lens[i] > 0 && b.addrs[i] != nil {
+				var segment int
+				if ln.gro {
+					segment = netpoll.GROSegmentSize(b.msgs.Control(i))
+				}
+				err = el.loopSegments(ln, b.addrs[i], b.bufs[i][:b.lens[i]], segment)
+			}
+			b.addrs[i] = nil
+		}
+		if dropped, werr := b.flush(); dropped > 0 {
+			el.logger.Printf("dropped %d UDP replies to fd:%d, error:%v\n", dropped, ln.fd, werr)
+		}
+		if err != nil || n < len(b.bufs) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDatagram writes the datagram to the address through fd, it is collected into the batch being reacted on
+// if the datagram goes through the same socket, and written right away otherwise.
+func (el *eventloop) sendDatagram(fd int, sa unix.Sockaddr, buf []byte) error {
+	if b := el.batch; b != nil && b.fd == fd {
+		b.out = append(b.out, buf...)
+		b.outEnds = append(b.outEnds, len(b.out))
+		b.outTo = append(b.outTo, sa)
+		return nil
+	}
+	return unix.Sendto(fd, buf, 0, sa)
+}
+
+// flush writes the replies collected and stops collecting them, the replies which can not be written
+// right away are dropped, as a single Sendto would do. It returns the number of the dropped replies
+// and the last error of writing them.
+func (b *datagramBatch) flush() (dropped int, err error) {
+	fd := b.fd
+	b.fd = -1
+	if len(b.outTo) == 0 {
+		return
+	}
+	start := 0
+	for _, end := range b.outEnds {
+		b.iov = append(b.iov, b.out[start:end])
+		start = end
+	}
+	for sent := 0; sent < len(b.iov); {
+		n, werr := b.msgs.Sendmmsg(fd, b.iov[sent:], b.outTo[sent:])
+		if werr == unix.EAGAIN || werr == unix.ENOSYS {
+			dropped, err = dropped+len(b.iov)-sent, werr
+			break
+		}
+		if werr != nil || n == 0 {
+			n = 1 // skip the datagram that fails
+			dropped, err = dropped+1, werr
+		}
+		sent += n
+	}
+	for i := range b.outTo {
+		b.outTo[i] = nil
+		b.iov[i] = nil
+	}
+	b.out, b.outEnds, b.outTo, b.iov = b.out[:0], b.outEnds[:0], b.outTo[:0], b.iov[:0]
+	return
+}
@@ -0,0 +1,82 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testBatchServer replies from a buffer reused across the datagrams.
+type testBatchServer struct {
+	*EventServer
+	buf []byte
+}
+
+func (es *testBatchServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	es.buf = append(append(es.buf[:0], "re:"...), frame...)
+	return es.buf, None
+}
+
+func TestUDPBatch(t *testing.T) {
+	for _, size := range []int{1, 8} {
+		h, err := Start(&testBatchServer{EventServer: new(EventServer)}, "udp://127.0.0.1:0", WithUDPBatchSize(size))
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		c, err := net.Dial("udp", h.Server().Addr.String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		const count = 100
+		for i := 0; i < count; i++ {
+			if _, err = c.Write([]byte(strconv.Itoa(i))); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+		}
+		seen := make(map[string]bool)
+		buf := make([]byte, 64)
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for len(seen) < count {
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatalf("batch size %d: got %d replies of %d: %v", size, len(seen), count, err)
+			}
+			seen[string(buf[:n])] = true
+		}
+		for i := 0; i < count; i++ {
+			if !seen["re:"+strconv.Itoa(i)] {
+				t.Fatalf("batch size %d: missing reply to %d", size, i)
+			}
+		}
+		_ = c.Close()
+		_ = h.Stop(context.Background())
+	}
+}
+
+func TestDatagramBatchSizes(t *testing.T) {
+	el, err := newEventLoop(0, defaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.poller.Close()
+	a := &listener{svr: &server{opts: &Options{UDPBatchSize: 8}}}
+	b := &listener{svr: &server{opts: &Options{UDPBatchSize: 16}}}
+	ba, bb := el.datagramBatch(a), el.datagramBatch(b)
+	if ba == nil || bb == nil || len(ba.bufs) != 8 || len(bb.bufs) != 16 {
+		t.Fatal("each server should get a batch of its size")
+	}
+	if el.datagramBatch(a) != ba || el.datagramBatch(b) != bb {
+		t.Fatal("the batches of the servers sharing the loop should be kept")
+	}
+	if ba.control || ba.msgs.Control(0) != nil {
+		t.Fatal("the control messages should not be read without the receive offload")
+	}
+	gro := &listener{svr: a.svr, gro: true}
+	if el.datagramBatch(gro) != ba || !ba.control {
+		t.Fatal("the control messages should be read once the batch is used with the receive offload")
+	}
+}
@@ -0,0 +1,103 @@
+// +build linux
+
+package netti
+
+import (
+	"netti/internal/netpoll"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxGSOSegments = 64    // UDP_MAX_SEGMENTS of the kernel
+	maxGSOBytes    = 65000 // bytes sent by one system call, below the limit of a UDP datagram
+)
+
+// gsoUnsupported is set once the kernel has rejected UDP_SEGMENT, the datagrams are sent one by one afterwards.
+var gsoUnsupported int32
+
+// loopReadGRO reads a datagram, possibly coalesced by the receive offload, from the listener.
+func (el *eventloop) loopReadGRO(ln *listener) error {
+	if el.control == nil {
+		el.control = make([]byte, netpoll.GROControlSize)
+	}
+	n, oobn, _, sa, err := unix.Recvmsg(ln.fd, el.packet, el.control, 0)
+	if err != nil || n == 0 || sa == nil {
+		if err != nil && err != unix.EAGAIN {
+			el.logger.Printf("failed to read UPD packet from fd:%d, error:%v\n", ln.fd, err)
+		}
+		return nil
+	}
+	return el.loopSegments(ln, sa, el.packet[:n], netpoll.GROSegmentSize(el.control[:oobn]))
+}
+
+// loopSegments splits the datagram coalesced by the receive offload into the datagrams of segment bytes sent by the
+// peer, the last one may be shorter, and reacts on each of them. A zero segment means the datagram is not coalesced.
+func (el *eventloop) loopSegments(ln *listener, sa unix.Sockaddr, data []byte, segment int) error {
+	if segment <= 0 || segment >= len(data) {
+		return el.loopDatagram(ln, sa, data)
+	}
+	for len(data) > 0 {
+		n := segment
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := el.loopDatagram(ln, sa, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (c *conn) SendToSegmented(buf []byte, segmentSize int) error {
+	if segmentSize <= 0 || len(buf) <= segmentSize {
+		return c.sendTo(buf)
+	}
+	segments := maxGSOBytes / segmentSize
+	if segments > maxGSOSegments {
+		segments = maxGSOSegments
+	} else if segments == 0 {
+		segments = 1
+	}
+	for len(buf) > 0 {
+		n := segments * segmentSize
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if err := c.sendSegmented(buf[:n], segmentSize); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// sendSegmented sends buf as the datagrams of size bytes by the segmentation offload, or one by one if the kernel
+// rejects it.
+func (c *conn) sendSegmented(buf []byte, size int) error {
+	if atomic.LoadInt32(&gsoUnsupported) == 0 {
+		switch err := netpoll.SendSegmented(c.fd, buf, size, c.sa); err {
+		case nil:
+			return nil
+		case unix.ENOPROTOOPT, unix.EOPNOTSUPP:
+			atomic.StoreInt32(&gsoUnsupported, 1)
+		case unix.EINVAL, unix.EIO:
+			// Rejected for this route or device, e.g. without checksum offload.
+		default:
+			return err
+		}
+	}
+	for len(buf) > 0 {
+		n := size
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if err := unix.Sendto(c.fd, buf[:n], 0, c.sa); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
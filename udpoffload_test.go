@@ -0,0 +1,102 @@
+// +build linux
+
+package netti
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"netti/internal/netpoll"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testOffloadServer reports the sizes of the datagrams, replies to "segments" with 10 datagrams of 100 bytes
+// and to "large" with 2 datagrams larger than a segmentation offload can carry at once.
+type testOffloadServer struct {
+	*EventServer
+	sizes chan int
+}
+
+func (es *testOffloadServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "segments" {
+		_ = c.SendToSegmented(bytes.Repeat([]byte("0123456789"), 100), 100)
+		return
+	}
+	if string(frame) == "large" {
+		_ = c.SendToSegmented(make([]byte, 2*testLargeSegment), testLargeSegment)
+		return
+	}
+	es.sizes <- len(frame)
+	return
+}
+
+// testLargeSegment is a segment size larger than maxGSOBytes.
+const testLargeSegment = 65001
+
+func TestUDPOffload(t *testing.T) {
+	for _, size := range []int{1, 8} {
+		es := &testOffloadServer{EventServer: new(EventServer), sizes: make(chan int, 16)}
+		h, err := Start(es, "udp://127.0.0.1:0", WithUDPGRO(true), WithUDPBatchSize(size))
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		c, err := net.DialUDP("udp", nil, h.Server().Addr.(*net.UDPAddr))
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		// The datagrams sent by the segmentation offload, coalesced or not on receiving, are reacted on one by one.
+		raw, _ := c.SyscallConn()
+		to := &unix.SockaddrInet4{Port: h.Server().Addr.(*net.UDPAddr).Port, Addr: [4]byte{127, 0, 0, 1}}
+		var sendErr error
+		_ = raw.Control(func(fd uintptr) {
+			if err := netpoll.SendSegmented(int(fd), make([]byte, 10), 1<<16, to); err != unix.EINVAL {
+				t.Errorf("a segment size out of uint16 should be rejected, got: %v", err)
+			}
+			sendErr = netpoll.SendSegmented(int(fd), make([]byte, 5*100+50), 100, to)
+		})
+		if sendErr != nil {
+			t.Skipf("UDP_SEGMENT is not supported: %v", sendErr)
+		}
+		for i, want := range []int{100, 100, 100, 100, 100, 50} {
+			select {
+			case got := <-es.sizes:
+				if got != want {
+					t.Fatalf("batch size %d: datagram %d has %d bytes, want %d", size, i, got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("batch size %d: timed out waiting for datagram %d", size, i)
+			}
+		}
+
+		// The reply sent by SendToSegmented arrives as separate datagrams.
+		if _, err = c.Write([]byte("segments")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		buf := make([]byte, 2048)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		for i := 0; i < 10; i++ {
+			n, err := c.Read(buf)
+			if err != nil || n != 100 {
+				t.Fatalf("batch size %d: reply %d: %d bytes, %v", size, i, n, err)
+			}
+		}
+
+		// The segments too large to be sent together are sent one by one.
+		if _, err = c.Write([]byte("large")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		buf = make([]byte, 1<<16)
+		for i := 0; i < 2; i++ {
+			n, err := c.Read(buf)
+			if err != nil || n != testLargeSegment {
+				t.Fatalf("batch size %d: large reply %d: %d bytes, %v", size, i, n, err)
+			}
+		}
+		_ = c.Close()
+		_ = h.Stop(context.Background())
+	}
+}
@@ -0,0 +1,166 @@
+// +build linux
+
+package netti
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testSessionServer struct {
+	*EventServer
+	opened chan string
+	closed chan error
+}
+
+func (es *testSessionServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetContext(0)
+	es.opened <- c.RemoteAddr().String()
+	return
+}
+
+func (es *testSessionServer) OnClosed(c Conn, err error) (action Action) {
+	es.closed <- err
+	return
+}
+
+func (es *testSessionServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "close" {
+		return nil, Close
+	}
+	n := c.Context().(int) + 1
+	c.SetContext(n)
+	return []byte(strconv.Itoa(n)), None
+}
+
+func TestUDPSessions(t *testing.T) {
+	es := &testSessionServer{EventServer: new(EventServer), opened: make(chan string, 4), closed: make(chan error, 4)}
+	h, err := Start(es, "udp://127.0.0.1:0", WithNumEventLoop(4), WithUDPSessions(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	addr := h.Server().Addr.String()
+
+	request := func(c net.Conn, req, want string) {
+		t.Helper()
+		if _, err := c.Write([]byte(req)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 16)
+		n, err := c.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("unexpected reply to %q: %q, %v, want %q", req, buf[:n], err, want)
+		}
+	}
+
+	// The context is kept across the datagrams of a peer, apart from those of the other peers.
+	a, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer a.Close()
+	b, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer b.Close()
+	for i := 1; i <= 3; i++ {
+		request(a, "ping", strconv.Itoa(i))
+	}
+	request(b, "ping", "1")
+	if remote := <-es.opened; remote != a.LocalAddr().String() {
+		t.Fatalf("unexpected remote address of the session: %s", remote)
+	}
+	<-es.opened
+	if n := h.Stats().Connections; n != 2 {
+		t.Fatalf("unexpected number of sessions: %d", n)
+	}
+
+	// The session closed by the event handler is opened anew on the next datagram.
+	_, _ = b.Write([]byte("close"))
+	if err = <-es.closed; err != nil {
+		t.Fatalf("unexpected error of the closed session: %v", err)
+	}
+	request(b, "ping", "1")
+	<-es.opened
+
+	// The idle sessions expire.
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-es.closed:
+			if err != ErrSessionExpired {
+				t.Fatalf("the session should be expired, got: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the sessions to expire")
+		}
+	}
+	waitFor(t, "the sessions to be uncounted", func() bool { return h.Stats().Connections == 0 })
+	request(a, "ping", "1")
+	<-es.opened
+
+	if err = h.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+	if err = <-es.closed; err != ErrServerShutdown {
+		t.Fatalf("the session should be closed along with the server, got: %v", err)
+	}
+}
+
+func TestUDPSessionLimits(t *testing.T) {
+	deny, err := NewCIDRFilter(nil, []string{"127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := &testSessionServer{EventServer: new(EventServer), opened: make(chan string, 4), closed: make(chan error, 4)}
+	h, err := Start(es, "udp://0.0.0.0:0", WithUDPSessions(time.Minute), WithMaxUDPSessions(1),
+		WithAcceptFilter(deny))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer h.Stop(context.Background())
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: h.Server().Addr.(*net.UDPAddr).Port}
+
+	dial := func(local string) net.Conn {
+		t.Helper()
+		c, err := net.DialUDP("udp", &net.UDPAddr{IP: net.ParseIP(local)}, server)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		return c
+	}
+	replied := func(c net.Conn) bool {
+		_, _ = c.Write([]byte("ping"))
+		_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := c.Read(make([]byte, 16))
+		return err == nil && n > 0
+	}
+
+	// The peers denied by the filters do not open sessions.
+	denied := dial("127.0.0.2")
+	defer denied.Close()
+	if replied(denied) {
+		t.Fatal("the datagram of a denied peer should be dropped")
+	}
+
+	// The peers beyond MaxUDPSessions do not either until a session is closed.
+	a, b := dial("127.0.0.1"), dial("127.0.0.1")
+	defer a.Close()
+	defer b.Close()
+	if !replied(a) || replied(b) {
+		t.Fatal("the session beyond the limit should not be opened")
+	}
+	if stats := h.Stats(); stats.Connections != 1 || stats.Accepted != 1 || stats.Filtered != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	_, _ = a.Write([]byte("close"))
+	<-es.closed
+	if !replied(b) {
+		t.Fatal("a session should be opened once another one is closed")
+	}
+}
@@ -0,0 +1,165 @@
+// +build linux
+
+package netti
+
+import (
+	"netti/internal/timingwheel"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// datagramCodec passes on the data written to a UDP session as a datagram.
+var datagramCodec ICodec = new(BuiltInFrameCodec)
+
+// defaultMaxUDPSessions bounds the UDP sessions of a server if Options.MaxUDPSessions is not set.
+const defaultMaxUDPSessions = 1 << 16
+
+// sessionKey identifies the UDP session of a peer on a listener.
+type sessionKey struct {
+	fd   int
+	ip   [16]byte
+	port int
+	zone uint32
+}
+
+// newSessionKey returns the key of the session of the peer with the given address on the listener fd.
+func newSessionKey(fd int, sa unix.Sockaddr) sessionKey {
+	key := sessionKey{fd: fd}
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		copy(key.ip[:], sa.Addr[:])
+		key.port = sa.Port
+	case *unix.SockaddrInet6:
+		key.ip = sa.Addr
+		key.port = sa.Port
+		key.zone = sa.ZoneId
+	}
+	return key
+}
+
+// loopReadSession reacts on a datagram within the session of its peer, the first datagram of a peer opens the session
+// unless it is rejected, the datagram is dropped then.
+func (el *eventloop) loopReadSession(ln *listener, sa unix.Sockaddr, header *ProxyHeader, data []byte) error {
+	key := newSessionKey(ln.fd, sa)
+	c, ok := el.sessions[key]
+	if !ok {
+		ip, admitted := ln.svr.admitSession(sa)
+		if !admitted {
+			return nil
+		}
+		c = newUDPSession(el, sa, ln, key)
+		c.admitIP = ip
+		c.setProxyHeader(header)
+		if err := el.loopOpenSession(c); err != nil || !c.opened {
+			return err
+		}
+	} else if header != nil {
+		c.setProxyHeader(header)
+	}
+	c.lastRead = time.Now()
+	c.frames++
+	out, action := c.svr.eventHandler.React(data, c)
+	if out != nil {
+		c.sendSession(out)
+	}
+	return el.handleSessionAction(c, action)
+}
+
+// admitSession counts a new UDP session against MaxUDPSessions, then runs the accept filters on its peer and counts
+// it against the connection limits, as admit does with a connection.
+func (svr *server) admitSession(sa unix.Sockaddr) (ip string, ok bool) {
+	max := svr.opts.MaxUDPSessions
+	if max <= 0 {
+		max = defaultMaxUDPSessions
+	}
+	if atomic.AddInt32(&svr.sessions, 1) > int32(max) {
+		atomic.AddInt32(&svr.sessions, -1)
+		atomic.AddUint64(&svr.rejected, 1)
+		return "", false
+	}
+	if ip, ok, _ = svr.admitPeer(sa); !ok {
+		atomic.AddInt32(&svr.sessions, -1)
+	}
+	return
+}
+
+// loopOpenSession opens the session of a peer and watches it for expiry.
+func (el *eventloop) loopOpenSession(c *conn) error {
+	el.sessions[*c.session] = c
+	atomic.AddInt32(&el.connCount, 1)
+	atomic.AddInt32(&c.svr.connCount, 1)
+	c.opened = true
+	c.lastRead = time.Now()
+	c.lastWrite = c.lastRead
+	el.scheduleExpiry(c, c.ln.sessionIdle)
+	out, action := c.svr.eventHandler.OnOpened(c)
+	if out != nil {
+		c.sendSession(out)
+	}
+	return el.handleSessionAction(c, action)
+}
+
+// scheduleExpiry closes the session once no datagram has come from the peer for the idle timeout.
+func (el *eventloop) scheduleExpiry(c *conn, delay time.Duration) {
+	var t *timingwheel.Timer
+	t = timingwheel.NewTimer(delay, false, func() error {
+		delete(c.timers, t)
+		if !c.opened {
+			return nil
+		}
+		if d := c.ln.sessionIdle - time.Since(c.lastRead); d > 0 {
+			el.scheduleExpiry(c, d)
+			return nil
+		}
+		return el.loopCloseSession(c, ErrSessionExpired)
+	})
+	c.addTimer(t)
+}
+
+// handleSessionAction is handleAction of a UDP session, which has nothing buffered to flush on closing.
+func (el *eventloop) handleSessionAction(c *conn, action Action) error {
+	switch action {
+	case Close:
+		return el.loopCloseSession(c, nil)
+	case Shutdown:
+		return el.shutdown(c.svr)
+	}
+	return nil
+}
+
+// loopCloseSession closes the session, the socket is left to the listener.
+func (el *eventloop) loopCloseSession(c *conn, err error) error {
+	if el.sessions[*c.session] != c {
+		return nil // closed already
+	}
+	delete(el.sessions, *c.session)
+	atomic.AddInt32(&el.connCount, -1)
+	atomic.AddInt32(&c.svr.connCount, -1)
+	atomic.AddInt32(&c.svr.sessions, -1)
+	c.svr.release(c.admitIP)
+	c.stopTimers()
+	action := c.svr.eventHandler.OnClosed(c, err)
+	c.releaseSession()
+	if action == Shutdown {
+		return el.shutdown(c.svr)
+	}
+	return nil
+}
+
+// closeSessions closes the sessions matching the predicate, e.g. those of a removed listener.
+func (el *eventloop) closeSessions(match func(c *conn) bool, err error) {
+	for _, c := range el.sessions {
+		if match(c) {
+			sniffError(el.loopCloseSession(c, err))
+		}
+	}
+}
+
+// sendSession sends the data to the peer of the session as a datagram.
+func (c *conn) sendSession(buf []byte) {
+	if err := c.loop.sendDatagram(c.fd, c.sa, buf); err == nil {
+		c.lastWrite = time.Now()
+	}
+}